@@ -4,19 +4,484 @@ import (
 	"github.com/sloonz/uback/container"
 	uback "github.com/sloonz/uback/lib"
 
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	"filippo.io/age"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+const defaultCompressionLevel = 3
+
+// Wrap raw backup data from a source into the uback container format. data is closed on error, so
+// that the source can abort the backup.
+func sealBackup(data io.ReadCloser, recipients []age.Recipient, typ string, compressionLevel int) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		cw, err := container.NewWriter(pw, recipients, typ, compressionLevel)
+		if err != nil {
+			data.Close()
+			pw.CloseWithError(err)
+			return
+		}
+
+		_, err = io.Copy(cw, data)
+		if err != nil {
+			data.Close()
+			pw.CloseWithError(err)
+			return
+		}
+
+		err = data.Close()
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		pw.CloseWithError(cw.Close())
+	}()
+	return pr
+}
+
+// Make sure that the data of a sealed source is really in the uback container format, and encrypted
+// if expected: for example, an older uback on the remote side of a proxy ignores the request to seal
+// the backup and sends raw data
+func checkSealedBackup(data io.ReadCloser, encrypted bool) (io.ReadCloser, error) {
+	r, opts, err := container.PeekHeader(data)
+	if err != nil {
+		data.Close()
+		return nil, fmt.Errorf("source did not send a sealed backup: %v", err)
+	}
+
+	if encrypted && opts.String["Plain"] == "1" {
+		data.Close()
+		return nil, errors.New("source sent a plaintext backup, but an encrypted one was expected")
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, data}, nil
+}
+
+// Base snapshot chosen for a new backup, and why
+type baseSelection struct {
+	base         *uback.Snapshot // nil for a full backup
+	reason       string
+	warn         bool // if true, the reason is logged as a warning
+	differential bool
+}
+
+func (s *baseSelection) log() {
+	if s.warn {
+		logrus.Warn(s.reason)
+	} else {
+		logrus.Print(s.reason)
+	}
+}
+
+// Choose the base snapshot of a new backup, given the backups present on the destination (sorted, most
+// recent first) and the snapshots present on the source
+func selectBase(srcOpts *optionsBuilder, backups []uback.Backup, snapshotsSet map[uback.Snapshot]interface{}) (*baseSelection, error) {
+	differential, err := srcOpts.Options.GetBoolean("Differential", false)
+	if err != nil {
+		return nil, err
+	}
+
+	maxChainLength := 0
+	if srcOpts.Options.String["MaxChainLength"] != "" {
+		maxChainLength, err = strconv.Atoi(srcOpts.Options.String["MaxChainLength"])
+		if err != nil || maxChainLength < 1 {
+			return nil, fmt.Errorf("invalid MaxChainLength: %s", srcOpts.Options.String["MaxChainLength"])
+		}
+	}
+
+	full := func(reason string, warn bool) (*baseSelection, error) {
+		return &baseSelection{reason: reason, warn: warn, differential: differential}, nil
+	}
+
+	if cmdBackupForceFull {
+		return full("full backup forced by --force-full", false)
+	}
+	if srcOpts.Options.String["StateFile"] == "" {
+		return full("StateFile option missing, full backup forced", true)
+	}
+	if srcOpts.Options.String["FullInterval"] == "" {
+		return full("no interval between full backups given, full backup forced", true)
+	}
+
+	fullInterval, err := uback.ParseInterval(srcOpts.Options.String["FullInterval"])
+	if err != nil {
+		return nil, err
+	}
+
+	var lastFull, base *uback.Backup
+	for i, b := range backups {
+		_, ok := snapshotsSet[b.Snapshot]
+		if ok && base == nil && !differential {
+			base = &backups[i]
+		}
+		if b.BaseSnapshot == nil && lastFull == nil {
+			lastFull = &backups[i]
+			if ok && differential {
+				base = &backups[i]
+			}
+		}
+		if lastFull != nil && base != nil {
+			break
+		}
+	}
+	if lastFull == nil {
+		return full("no full backup found, full backup forced", true)
+	} else if base == nil && differential {
+		return full("snapshot of the last full backup not found, full backup forced", true)
+	} else if base == nil {
+		return full("no common snapshots found, full backup forced", true)
+	}
+
+	t, err := lastFull.Time()
+	if err != nil {
+		return nil, err
+	}
+
+	chain, complete := uback.GetFullChain(*base, uback.MakeIndex(backups))
+	if !complete {
+		return full(fmt.Sprintf("base of %s missing from the destination, full backup forced", chain[len(chain)-1].Snapshot), true)
+	} else if time.Now().UTC().Sub(t).Seconds() >= float64(fullInterval)*0.9 {
+		return full("interval between full backups reached, full backup forced", false)
+	} else if maxChainLength > 0 && len(chain) > maxChainLength {
+		return full("maximum chain length reached, full backup forced", false)
+	}
+
+	reason := fmt.Sprintf("incremental backup from %s, the most recent backup whose snapshot is on the source", base.Snapshot)
+	if differential {
+		reason = fmt.Sprintf("incremental backup from %s, the last full backup (differential mode)", base.Snapshot)
+	}
+	return &baseSelection{base: &base.Snapshot, reason: reason, differential: differential}, nil
+}
+
+// List the backups of the destination and the archives and bookmarks of the source, most recent first
+func listBackupsAndSnapshots(srcOpts, dstOpts *optionsBuilder) ([]uback.Backup, []uback.Snapshot, []uback.Snapshot, error) {
+	backups, err := uback.SortedListBackups(dstOpts.Destination)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	archives, err := uback.SortedListArchives(srcOpts.Source)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	bookmarks, err := uback.SortedListBookmarks(srcOpts.Source)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return backups, archives, bookmarks, nil
+}
+
+func makeSnapshotsSet(archives, bookmarks []uback.Snapshot) map[uback.Snapshot]interface{} {
+	snapshotsSet := make(map[uback.Snapshot]interface{})
+	for _, s := range bookmarks {
+		snapshotsSet[s] = nil
+	}
+	for _, s := range archives {
+		snapshotsSet[s] = nil
+	}
+	return snapshotsSet
+}
+
+// Read the StateFile of a source, if any
+func readState(srcOpts *optionsBuilder) (map[string]string, error) {
+	state := make(map[string]string)
+	if srcOpts.Options.String["StateFile"] == "" {
+		return state, nil
+	}
+
+	rawState, err := os.ReadFile(srcOpts.Options.String["StateFile"])
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if rawState != nil {
+		err = json.Unmarshal(rawState, &state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return state, nil
+}
+
+// Record in the state the snapshot needed by the destination to create the next backup
+func updateState(state map[string]string, dstOpts *optionsBuilder, backup uback.Backup, differential bool) {
+	// In differential mode, the next backup will be based on the last full backup, so keep its
+	// snapshot instead
+	if differential && backup.BaseSnapshot != nil {
+		state[dstOpts.Options.String["ID"]] = string(*backup.BaseSnapshot)
+	} else {
+		state[dstOpts.Options.String["ID"]] = string(backup.Snapshot)
+	}
+}
+
+// Make the context of the creation of a backup: it is cancelled on SIGINT or SIGTERM, and after the
+// Timeout option of the source or of the destination, if any
+func newBackupContext(srcOpts, dstOpts *optionsBuilder) (context.Context, context.CancelFunc, error) {
+	srcTimeout, err := srcOpts.Options.GetDuration("Timeout", 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid source Timeout: %v", err)
+	}
+
+	dstTimeout, err := dstOpts.Options.GetDuration("Timeout", 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid destination Timeout: %v", err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			// Restore the default behavior, so that a second signal terminates uback immediately
+			signal.Stop(signals)
+			logrus.Warnf("received %v, cancelling backup", sig)
+			cancel(fmt.Errorf("interrupted by %v", sig))
+		case <-ctx.Done():
+		}
+	}()
+
+	cancels := []context.CancelFunc{func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}}
+	if srcTimeout > 0 {
+		var c context.CancelFunc
+		ctx, c = context.WithTimeoutCause(ctx, srcTimeout, fmt.Errorf("source timeout (%v) exceeded", srcTimeout))
+		cancels = append(cancels, c)
+	}
+	if dstTimeout > 0 {
+		var c context.CancelFunc
+		ctx, c = context.WithTimeoutCause(ctx, dstTimeout, fmt.Errorf("destination timeout (%v) exceeded", dstTimeout))
+		cancels = append(cancels, c)
+	}
+
+	return ctx, func() {
+		for i := len(cancels) - 1; i >= 0; i-- {
+			cancels[i]()
+		}
+	}, nil
+}
+
+// Create a backup of the source and send it to the destination, then prune old snapshots and backups.
+// The creation of the backup is aborted when ctx is done.
+func runBackup(ctx context.Context, srcOpts, dstOpts *optionsBuilder, h *hooks, report *jobReport) error {
+	compressionLevel := defaultCompressionLevel
+	// TODO: read compression level from options
+
+	backups, archives, bookmarks, err := listBackupsAndSnapshots(srcOpts, dstOpts)
+	if err != nil {
+		return err
+	}
+
+	selection, err := selectBase(srcOpts, backups, makeSnapshotsSet(archives, bookmarks))
+	if err != nil {
+		return err
+	}
+	selection.log()
+
+	backup, data, err := uback.CreateBackupContext(ctx, srcOpts.Source, selection.base)
+	if err != nil {
+		return err
+	}
+	// Make sure the source has cleaned up before returning, even if the backup failed
+	defer data.Close()
+	h.setBackup(backup)
+	report.Backup = backup.FullName()
+	report.bytesRead = &countingReader{ReadCloser: data}
+	data = report.bytesRead
+
+	var sealed io.ReadCloser
+	var catalog *catalogBuilder
+	if ss, ok := srcOpts.Source.(uback.SealedSource); ok && ss.IsSealed() {
+		sealed, err = checkSealedBackup(data, len(srcOpts.Recipients) > 0)
+		if err != nil {
+			return err
+		}
+	} else {
+		catalog, data = newCatalogBuilder(dstOpts.Destination, backup, srcOpts.SourceType, data)
+		sealed = sealBackup(data, srcOpts.Recipients, srcOpts.SourceType, compressionLevel)
+	}
+	report.bytesWritten = &countingReader{ReadCloser: sealed}
+	sealed = report.bytesWritten
+	defer sealed.Close()
+
+	err = uback.SendBackupContext(ctx, dstOpts.Destination, backup, sealed)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("backup cancelled: %v", context.Cause(ctx))
+		}
+		return err
+	}
+
+	if catalog != nil {
+		err = catalog.send(dstOpts.Destination, backup, srcOpts.Recipients, compressionLevel)
+		if err != nil {
+			logrus.Warnf("cannot create catalog: %v", err)
+		}
+	}
+
+	state, err := readState(srcOpts)
+	if err != nil {
+		return err
+	}
+
+	if srcOpts.Options.String["StateFile"] != "" {
+		updateState(state, dstOpts, backup, selection.differential)
+		rawState, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(srcOpts.Options.String["StateFile"], rawState, 0o666)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println(backup.FullName())
+
+	backups = append([]uback.Backup{backup}, backups...)
+	if !cmdBackupNoPrune {
+		prunedSnapshots, err := uback.PruneSnapshots(srcOpts.Source, srcOpts.RetentionPolicies, state)
+		if err != nil {
+			logrus.Warnf("cannot prune snapshots: %v", err)
+		}
+		for _, s := range prunedSnapshots {
+			report.PrunedSnapshots = append(report.PrunedSnapshots, string(s))
+		}
+
+		prunedBackups, err := uback.PruneBackups(dstOpts.Destination, backups, dstOpts.RetentionPolicies, dstOpts.MaxSize)
+		if err != nil {
+			logrus.Warnf("cannot prune backups: %v", err)
+		}
+		for _, b := range prunedBackups {
+			report.PrunedBackups = append(report.PrunedBackups, b.FullName())
+		}
+	}
+	report.setBackups(backups)
+
+	return nil
+}
+
+// Print what a backup would do, without creating any snapshot nor backup
+func runBackupDryRun(srcOpts, dstOpts *optionsBuilder) error {
+	backups, archives, bookmarks, err := listBackupsAndSnapshots(srcOpts, dstOpts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("source: %s\n", srcOpts.SourceType)
+	fmt.Printf("destination: %s (%s)\n", dstOpts.Options.String["ID"], dstOpts.Options.String["Type"])
+	fmt.Printf("backups on destination: %d\n", len(backups))
+	fmt.Printf("archives on source: %d\n", len(archives))
+	fmt.Printf("bookmarks on source: %d\n", len(bookmarks))
+
+	selection, err := selectBase(srcOpts, backups, makeSnapshotsSet(archives, bookmarks))
+	if err != nil {
+		return err
+	}
+
+	// The source may still decide to create a full backup
+	backup := uback.Backup{Snapshot: uback.Snapshot(time.Now().UTC().Format(uback.SnapshotTimeFormat)), BaseSnapshot: selection.base}
+	fmt.Printf("backup: %s\n", backup.FullName())
+	fmt.Printf("reason: %s\n", selection.reason)
+
+	estimatedSize := int64(-1)
+	if se, ok := srcOpts.Source.(uback.SizeEstimator); ok {
+		size, err := se.EstimateBackupSize(selection.base)
+		if err != nil {
+			logrus.Warnf("cannot estimate backup size: %v", err)
+		} else {
+			estimatedSize = size
+		}
+	}
+	if estimatedSize >= 0 {
+		fmt.Printf("estimated size: %s (before compression)\n", uback.FormatSize(estimatedSize))
+	} else {
+		fmt.Printf("estimated size: unknown\n")
+	}
+
+	if cmdBackupNoPrune {
+		return nil
+	}
+
+	state, err := readState(srcOpts)
+	if err != nil {
+		return err
+	}
+	updateState(state, dstOpts, backup, selection.differential)
+
+	// Whether the new snapshot will be an archive or a bookmark depends on the source; assume it will be
+	// like the existing ones
+	if len(archives) > 0 || len(bookmarks) == 0 {
+		archives = append([]uback.Snapshot{backup.Snapshot}, archives...)
+	} else {
+		bookmarks = append([]uback.Snapshot{backup.Snapshot}, bookmarks...)
+	}
+
+	archivesExplanations, bookmarksExplanations, err := uback.ExplainPrunedSnapshots(archives, bookmarks, srcOpts.RetentionPolicies, state)
+	if err != nil {
+		return err
+	}
+	for _, e := range append(archivesExplanations, bookmarksExplanations...) {
+		if !e.Retained {
+			fmt.Printf("prune snapshot: %s (%s)\n", e.Name, retentionReasons(e))
+		}
+	}
+
+	// Like uback.GetDestinationPrunedBackups, keep everything without retention policies
+	if len(dstOpts.RetentionPolicies) == 0 && dstOpts.MaxSize == 0 {
+		return nil
+	}
+
+	// Use the size before compression as an upper bound of the size of the new backup
+	backups = append([]uback.Backup{backup}, backups...)
+	explanations, usage, err := uback.ExplainPendingDestinationPruning(dstOpts.Destination, backups, dstOpts.RetentionPolicies, dstOpts.MaxSize, map[string]int64{backup.FullName(): max(estimatedSize, 0)})
+	if err != nil {
+		return err
+	}
+
+	for i, e := range explanations {
+		if !e.Retained && i > 0 {
+			fmt.Printf("prune backup: %s (%s)\n", e.Name, retentionReasons(e))
+		}
+	}
+
+	if dstOpts.MaxSize > 0 {
+		if estimatedSize >= 0 {
+			fmt.Printf("backups size after pruning: %s (MaxSize: %s, using the estimated size of the new backup)\n", uback.FormatSize(usage), uback.FormatSize(dstOpts.MaxSize))
+		} else {
+			fmt.Printf("backups size after pruning: %s (MaxSize: %s, excluding the new backup)\n", uback.FormatSize(usage), uback.FormatSize(dstOpts.MaxSize))
+		}
+	}
+
+	return nil
+}
+
 var (
 	cmdBackupForceFull bool
 	cmdBackupNoPrune   bool
+	cmdBackupDryRun    bool
 
 	cmdBackup = &cobra.Command{
 		Use:   "backup <source> <destination>",
@@ -34,159 +499,41 @@ var (
 				WithDestination().
 				WithStringOption("ID").
 				WithRetentionPolicies().
+				WithMaxSize().
 				FatalOnError()
 
-			compressionLevel := 3
-			// TODO: read compression level from options
-
-			backups, err := uback.SortedListBackups(dstOpts.Destination)
-			if err != nil {
-				logrus.Fatal(err)
+			if cmdBackupDryRun {
+				err := runBackupDryRun(srcOpts, dstOpts)
+				if err != nil {
+					logrus.Fatal(err)
+				}
+				return
 			}
 
-			bookmarks, err := uback.SortedListBookmarks(srcOpts.Source)
+			h, err := newHooks("backup", srcOpts.Options, srcOpts.SourceType, dstOpts.Options)
 			if err != nil {
 				logrus.Fatal(err)
 			}
 
-			archives, err := uback.SortedListArchives(srcOpts.Source)
+			ctx, cancel, err := newBackupContext(srcOpts, dstOpts)
 			if err != nil {
 				logrus.Fatal(err)
 			}
 
-			snapshotsSet := make(map[uback.Snapshot]interface{})
-			for _, s := range bookmarks {
-				snapshotsSet[s] = nil
-			}
-			for _, s := range archives {
-				snapshotsSet[s] = nil
+			report := newJobReport("backup", srcOpts.Options, srcOpts.SourceType, dstOpts.Options)
+			err = h.run("PreCommand")
+			if err == nil {
+				err = runBackup(ctx, srcOpts, dstOpts, h, report)
 			}
+			cancel()
 
-			forceFull := cmdBackupForceFull
-			var fullInterval int
-			var lastCommon *uback.Snapshot
-			if !forceFull {
-				if srcOpts.Options.String["StateFile"] == "" {
-					logrus.Warn("StateFile option missing, full backup forced")
-					forceFull = true
-				} else if srcOpts.Options.String["FullInterval"] == "" {
-					logrus.Warn("no interval between full backups given, full backup forced")
-					forceFull = true
-				} else {
-					fullInterval, err = uback.ParseInterval(srcOpts.Options.String["FullInterval"])
-					if err != nil {
-						logrus.Fatal(err)
-					}
-				}
-			}
-			if !forceFull {
-				var lastFull *uback.Backup
-				for i, b := range backups {
-					_, ok := snapshotsSet[b.Snapshot]
-					if ok && lastCommon == nil {
-						lastCommon = &backups[i].Snapshot
-					}
-					if b.BaseSnapshot == nil && lastFull == nil {
-						lastFull = &backups[i]
-					}
-					if lastFull != nil && lastCommon != nil {
-						break
-					}
-				}
-				if lastFull == nil {
-					logrus.Warn("no full backup found, full backup forced")
-					forceFull = true
-				} else if lastCommon == nil {
-					logrus.Warn("no common snapshots found, full backup forced")
-					forceFull = true
-				} else {
-					t, err := lastFull.Time()
-					if err != nil {
-						logrus.Fatal(err)
-					}
-
-					if time.Now().UTC().Sub(t).Seconds() >= float64(fullInterval)*0.9 {
-						logrus.Printf("interval between full backups reached, full backup forced")
-						forceFull = true
-					}
-				}
-			}
-			if forceFull {
-				lastCommon = nil
-			}
-
-			backup, data, err := srcOpts.Source.CreateBackup(lastCommon)
-			if err != nil {
-				logrus.Fatal(err)
-			}
-
-			pr, pw := io.Pipe()
-			go func() {
-				cw, err := container.NewWriter(pw, srcOpts.Recipients, srcOpts.SourceType, compressionLevel)
-				if err != nil {
-					pw.CloseWithError(err)
-					return
-				}
-
-				_, err = io.Copy(cw, data)
-				if err != nil {
-					pw.CloseWithError(err)
-					return
-				}
-
-				err = data.Close()
-				if err != nil {
-					pw.CloseWithError(err)
-					return
-				}
-
-				pw.CloseWithError(cw.Close())
-			}()
-
-			err = dstOpts.Destination.SendBackup(backup, pr)
+			err = h.finish(err)
+			report.finish(err)
+			notify(report, srcOpts.Options, dstOpts.Options)
+			exportMetrics(report)
 			if err != nil {
 				logrus.Fatal(err)
 			}
-
-			state := make(map[string]string)
-			if srcOpts.Options.String["StateFile"] != "" {
-				rawState, err := os.ReadFile(srcOpts.Options.String["StateFile"])
-				if err != nil && !os.IsNotExist(err) {
-					logrus.Fatal(err)
-				}
-
-				if rawState != nil {
-					err = json.Unmarshal(rawState, &state)
-					if err != nil {
-						logrus.Fatal(err)
-					}
-				}
-
-				state[dstOpts.Options.String["ID"]] = string(backup.Snapshot)
-				rawState, err = json.Marshal(state)
-				if err != nil {
-					logrus.Fatal(err)
-				}
-
-				err = os.WriteFile(srcOpts.Options.String["StateFile"], rawState, 0o666)
-				if err != nil {
-					logrus.Fatal(err)
-				}
-			}
-
-			fmt.Println(backup.FullName())
-
-			if !cmdBackupNoPrune {
-				err = uback.PruneSnapshots(srcOpts.Source, srcOpts.RetentionPolicies, state)
-				if err != nil {
-					logrus.Warnf("cannot prune snapshots: %v", err)
-				}
-
-				err = uback.PruneBackups(dstOpts.Destination, append([]uback.Backup{backup}, backups...), dstOpts.RetentionPolicies)
-				if err != nil {
-					logrus.Warnf("cannot prune backups: %v", err)
-				}
-			}
 		},
 	}
 )
@@ -194,4 +541,6 @@ var (
 func init() {
 	cmdBackup.Flags().BoolVarP(&cmdBackupForceFull, "force-full", "f", false, "force full backup")
 	cmdBackup.Flags().BoolVarP(&cmdBackupNoPrune, "no-prune", "n", false, "do not prune snapshots and backups")
+	cmdBackup.Flags().BoolVar(&cmdBackupDryRun, "dry-run", false, "print what the backup would do, without creating any snapshot or backup")
+	cmdBackup.Flags().StringVar(&metricsTextfile, "metrics-textfile", "", "write Prometheus metrics to this file (node_exporter textfile collector format)")
 }
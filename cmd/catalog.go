@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"github.com/sloonz/uback/container"
+	"github.com/sloonz/uback/lib"
+	"github.com/sloonz/uback/sources"
+
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"filippo.io/age"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var ErrNoCatalogs = errors.New("destination does not support catalogs")
+
+// Build the catalog of a backup from its raw data, while it is being sent to a destination
+type catalogBuilder struct {
+	pw      *io.PipeWriter
+	done    chan error
+	entries map[string]uback.BackupEntry
+}
+
+// Return a catalog builder and the wrapped backup data feeding it, or a nil builder if catalogs are
+// not supported by the source type or by the destination
+func newCatalogBuilder(dst uback.Destination, backup uback.Backup, typ string, data io.ReadCloser) (*catalogBuilder, io.ReadCloser) {
+	if _, ok := dst.(uback.CatalogDestination); !ok {
+		return nil, data
+	}
+
+	src, err := sources.NewForRestoration(&uback.Options{}, typ)
+	if err != nil {
+		return nil, data
+	}
+
+	browsableSrc, ok := src.(uback.BrowsableSource)
+	if !ok {
+		return nil, data
+	}
+
+	pr, pw := io.Pipe()
+	cb := &catalogBuilder{pw: pw, done: make(chan error, 1), entries: make(map[string]uback.BackupEntry)}
+	go func() {
+		err := browsableSrc.ListBackupEntries(cb.entries, backup, pr)
+
+		// Never block the backup, even if the catalog cannot be built
+		_, _ = io.Copy(io.Discard, pr)
+		cb.done <- err
+	}()
+
+	return cb, &catalogReader{ReadCloser: data, r: io.TeeReader(data, pw), pw: pw}
+}
+
+// Wait for the whole backup data to be read, then seal the catalog and send it to the destination
+func (cb *catalogBuilder) send(dst uback.Destination, backup uback.Backup, recipients []age.Recipient, compressionLevel int) error {
+	err := <-cb.done
+	if err != nil {
+		return err
+	}
+
+	names := sortedEntryNames(cb.entries)
+	buf := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(buf)
+	for _, name := range names {
+		err = enc.Encode(cb.entries[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	sealed := sealBackup(io.NopCloser(buf), recipients, "catalog", compressionLevel)
+	defer sealed.Close()
+
+	logrus.Printf("sending catalog of %s (%d entries)", backup.FullName(), len(names))
+	return dst.(uback.CatalogDestination).SendCatalog(backup, sealed)
+}
+
+type catalogReader struct {
+	io.ReadCloser
+	r  io.Reader
+	pw *io.PipeWriter
+}
+
+func (cr *catalogReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if err == io.EOF {
+		cr.pw.Close()
+	} else if err != nil {
+		cr.pw.CloseWithError(err)
+	}
+	return n, err
+}
+
+func (cr *catalogReader) Close() error {
+	cr.pw.Close()
+	return cr.ReadCloser.Close()
+}
+
+// Call f on each entry of the catalog of a backup
+func readCatalog(dst uback.CatalogDestination, backup uback.Backup, sk []age.Identity, f func(entry uback.BackupEntry) error) error {
+	data, err := dst.ReceiveCatalog(backup)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	r, err := container.NewReader(data)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = r.Unseal(sk)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var entry uback.BackupEntry
+		err = dec.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = f(entry)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+var cmdFind = &cobra.Command{
+	Use:   "find <destination> <pattern>",
+	Short: "Find the versions of files matching a pattern in the catalogs of backups",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dstOpts := newOptionsBuilder(uback.EvalOptions(uback.SplitOptions(args[0]), presets)).
+			WithDestination().
+			WithIdentities().
+			FatalOnError()
+
+		cd, ok := dstOpts.Destination.(uback.CatalogDestination)
+		if !ok {
+			logrus.Fatal(ErrNoCatalogs)
+		}
+
+		backups, err := cd.ListCatalogs()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		sort.Slice(backups, func(a, b int) bool {
+			return uback.CompareBackups(backups[a], backups[b]) < 0
+		})
+
+		pattern := args[1]
+		for _, b := range backups {
+			err = readCatalog(cd, b, dstOpts.Identities, func(e uback.BackupEntry) error {
+				// Like find -name, also match the pattern against the file name only
+				if ok, _ := path.Match(pattern, path.Base(e.Path)); ok || uback.MatchBackupPath([]string{pattern}, e.Path) {
+					fmt.Printf("%s %v %12d %s %s %s\n", b.FullName(), e.Mode, e.Size, e.ModTime.Local().Format("2006-01-02 15:04"), hashOrDash(e.Hash), e.Path)
+				}
+				return nil
+			})
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"backup": b.FullName()}).Warnf("cannot read catalog: %v", err)
+			}
+		}
+	},
+}
+
+func hashOrDash(hash string) string {
+	if hash == "" {
+		return "-"
+	}
+	return hash
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"github.com/sloonz/uback/lib"
+
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var ErrNoHolds = errors.New("destination does not support holds")
+
+var cmdHold = &cobra.Command{
+	Use:   "hold <destination> <backup-name>",
+	Short: "Hold a backup, preventing it (and the backups it depends on) from being pruned",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dstOpts := newOptionsBuilder(uback.EvalOptions(uback.SplitOptions(args[0]), presets)).
+			WithDestination().
+			FatalOnError()
+
+		hd, ok := dstOpts.Destination.(uback.HoldDestination)
+		if !ok {
+			logrus.Fatal(ErrNoHolds)
+		}
+
+		backups, err := uback.SortedListBackups(dstOpts.Destination)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		backup, err := findBackup(backups, args[1])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		err = hd.HoldBackup(backup)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		fmt.Println(backup.FullName())
+	},
+}
+
+var cmdRelease = &cobra.Command{
+	Use:   "release <destination> <backup-name>",
+	Short: "Release a held backup",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		dstOpts := newOptionsBuilder(uback.EvalOptions(uback.SplitOptions(args[0]), presets)).
+			WithDestination().
+			FatalOnError()
+
+		hd, ok := dstOpts.Destination.(uback.HoldDestination)
+		if !ok {
+			logrus.Fatal(ErrNoHolds)
+		}
+
+		held, err := hd.ListHolds()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		sort.Slice(held, func(a, b int) bool {
+			return uback.CompareBackups(held[a], held[b]) >= 0
+		})
+
+		backup, err := findBackup(held, args[1])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		err = hd.ReleaseBackup(backup)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		fmt.Println(backup.FullName())
+	},
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"github.com/sloonz/uback/lib"
+
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrHookFailurePolicy = errors.New("invalid HookFailure option: must be abort or warn")
+	hooksLog             = logrus.WithFields(logrus.Fields{
+		"component": "hooks",
+	})
+)
+
+// Hook commands defined in a set of options
+type hookSet struct {
+	options *uback.Options
+	abort   bool
+}
+
+// User-defined commands run around a backup or a restoration
+// (@PreCommand, @PostCommand, @OnSuccess and @OnFailure options)
+type hooks struct {
+	sets []hookSet
+	env  []string
+}
+
+// Create hooks from source and destination options. Hooks defined in the source options are run
+// before hooks defined in the destination options. Either set of options may be nil.
+func newHooks(command string, srcOpts *uback.Options, srcType string, dstOpts *uback.Options) (*hooks, error) {
+	h := &hooks{env: []string{"UBACK_COMMAND=" + command}}
+	if srcType != "" {
+		h.env = append(h.env, "UBACK_SOURCE_TYPE="+srcType)
+	}
+	if dstOpts != nil {
+		h.env = append(h.env, "UBACK_DESTINATION_ID="+dstOpts.String["ID"])
+	}
+
+	for _, s := range []struct {
+		options *uback.Options
+		prefix  string
+	}{{srcOpts, "UBACK_SRC_"}, {dstOpts, "UBACK_DST_"}} {
+		if s.options == nil {
+			continue
+		}
+
+		env, err := s.options.Environment(s.prefix)
+		if err != nil {
+			return nil, err
+		}
+		h.env = append(h.env, env...)
+
+		var abort bool
+		switch s.options.GetString("HookFailure", "abort") {
+		case "abort":
+			abort = true
+		case "warn":
+			abort = false
+		default:
+			return nil, ErrHookFailurePolicy
+		}
+
+		h.sets = append(h.sets, hookSet{options: s.options, abort: abort})
+	}
+
+	return h, nil
+}
+
+// Set the backup being created or restored, exposed to hooks run afterwards
+func (h *hooks) setBackup(backup uback.Backup) {
+	h.env = append(h.env, "UBACK_SNAPSHOT="+string(backup.Snapshot), "UBACK_BACKUP="+backup.FullName())
+	if backup.BaseSnapshot != nil {
+		h.env = append(h.env, "UBACK_BASE_SNAPSHOT="+string(*backup.BaseSnapshot))
+	}
+}
+
+// Run the hook named name (PreCommand, PostCommand, OnSuccess or OnFailure) of each set of options.
+// Failures are only logged, unless the HookFailure policy of the set is abort, in which case the
+// error is returned.
+func (h *hooks) run(name string, extraEnv ...string) error {
+	for _, s := range h.sets {
+		command := s.options.GetCommand(name, nil)
+		if len(command) == 0 {
+			continue
+		}
+
+		timeout, err := s.options.GetDuration("HookTimeout", 0)
+		if err != nil {
+			return err
+		}
+
+		err = h.runCommand(name, command, timeout, extraEnv)
+		if err != nil {
+			err = fmt.Errorf("%s hook failed: %v", name, err)
+			if s.abort {
+				return err
+			}
+			hooksLog.Warn(err)
+		}
+	}
+
+	return nil
+}
+
+func (h *hooks) runCommand(name string, command []string, timeout time.Duration, extraEnv []string) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = os.Stderr // like uback.BuildCommand, keep our own output clean
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(append(append(os.Environ(), h.env...), "UBACK_HOOK="+name), extraEnv...)
+
+	err := uback.RunCommand(hooksLog, cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timeout after %v", timeout)
+	}
+	return err
+}
+
+// Run the hooks following an operation whose outcome is opErr: OnSuccess or OnFailure, then PostCommand.
+// Returns opErr if it is not nil, or else the first hook error.
+func (h *hooks) finish(opErr error) error {
+	var extraEnv []string
+	var err error
+	if opErr == nil {
+		extraEnv = []string{"UBACK_STATUS=success"}
+		err = h.run("OnSuccess", extraEnv...)
+	} else {
+		extraEnv = []string{"UBACK_STATUS=failure", "UBACK_ERROR=" + opErr.Error()}
+		err = h.run("OnFailure", extraEnv...)
+	}
+
+	postErr := h.run("PostCommand", extraEnv...)
+	if opErr != nil {
+		if err != nil {
+			hooksLog.Warn(err)
+		}
+		if postErr != nil {
+			hooksLog.Warn(postErr)
+		}
+		return opErr
+	}
+
+	if err != nil {
+		if postErr != nil {
+			hooksLog.Warn(postErr)
+		}
+		return err
+	}
+
+	return postErr
+}
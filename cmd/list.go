@@ -23,12 +23,26 @@ var cmdListBackups = &cobra.Command{
 			logrus.Fatal(err)
 		}
 
+		heldBackups, err := uback.ListHeldBackups(dstOpts.Destination)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+
+		held := make(map[string]bool)
+		for _, b := range heldBackups {
+			held[b.FullName()] = true
+		}
+
 		for i := len(backups) - 1; i >= 0; i-- {
 			b := backups[i]
+			holdMark := ""
+			if held[b.FullName()] {
+				holdMark = " [held]"
+			}
 			if b.BaseSnapshot == nil {
-				fmt.Printf("%s (full)\n", b.Snapshot.Name())
+				fmt.Printf("%s (full)%s\n", b.Snapshot.Name(), holdMark)
 			} else {
-				fmt.Printf("%s (base: %s)\n", b.Snapshot.Name(), b.BaseSnapshot.Name())
+				fmt.Printf("%s (base: %s)%s\n", b.Snapshot.Name(), b.BaseSnapshot.Name(), holdMark)
 			}
 		}
 	},
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"github.com/sloonz/uback/lib"
+
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cmdLsSourceOptions string
+	cmdLs              = &cobra.Command{
+		Use:   "ls <destination> <backup-name> [path]",
+		Short: "List the content of a backup",
+		Args:  cobra.RangeArgs(2, 3),
+		Run: func(cmd *cobra.Command, args []string) {
+			dstOpts := newOptionsBuilder(uback.EvalOptions(uback.SplitOptions(args[0]), presets)).
+				WithDestination().
+				WithIdentities().
+				FatalOnError()
+
+			srcOpts, err := uback.EvalOptions(uback.SplitOptions(cmdLsSourceOptions), presets)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
+			chain, err := findBackupChain(dstOpts.Destination, args[1])
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
+			entries := make(map[string]uback.BackupEntry)
+			for i := len(chain) - 1; i >= 0; i-- {
+				b := chain[i]
+				err = withBackup(dstOpts.Destination, b, dstOpts.Identities, srcOpts, "", func(src uback.Source, data io.Reader) error {
+					browsableSrc, ok := src.(uback.BrowsableSource)
+					if !ok {
+						return ErrNotBrowsable
+					}
+					return browsableSrc.ListBackupEntries(entries, b, data)
+				})
+				if err != nil {
+					logrus.Fatal(err)
+				}
+			}
+
+			for _, name := range sortedEntryNames(entries) {
+				if len(args) > 2 && !uback.MatchBackupPath([]string{args[2]}, name) {
+					continue
+				}
+
+				e := entries[name]
+				line := fmt.Sprintf("%v %12d %s %s", e.Mode, e.Size, e.ModTime.Local().Format("2006-01-02 15:04"), e.Path)
+				if e.Linkname != "" {
+					if e.Mode&fs.ModeSymlink != 0 {
+						line += " -> " + e.Linkname
+					} else {
+						line += " link to " + e.Linkname
+					}
+				}
+				fmt.Println(line)
+			}
+		},
+	}
+)
+
+func init() {
+	cmdLs.Flags().StringVarP(&cmdLsSourceOptions, "source-options", "o", "", "additional source options")
+}
+
+func sortedEntryNames(entries map[string]uback.BackupEntry) []string {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var metricsTextfile string
+
+type metric struct {
+	name  string
+	help  string
+	value float64
+}
+
+// Format the labels of the metrics of a job, in the Prometheus exposition format
+func (r *jobReport) metricsLabels() string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	labels := [][2]string{
+		{"command", r.Command},
+		{"source_type", r.SourceType},
+		{"destination_type", r.DestinationType},
+		{"destination_id", r.DestinationID},
+	}
+
+	var parts []string
+	for _, l := range labels {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, l[0], escape.Replace(l[1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func (r *jobReport) metrics() []metric {
+	success := 0.
+	if r.Status == "success" {
+		success = 1.
+	}
+
+	metrics := []metric{
+		{"uback_last_run_timestamp_seconds", "Start time of the last run", float64(r.StartedAt.Unix())},
+		{"uback_last_run_success", "Whether the last run succeeded", success},
+		{"uback_last_run_duration_seconds", "Duration of the last run", r.Duration},
+	}
+	if r.Status == "success" {
+		metrics = append(metrics, metric{"uback_last_success_timestamp_seconds", "Start time of the last successful run", float64(r.StartedAt.Unix())})
+	}
+	if r.Command == "backup" {
+		metrics = append(metrics,
+			metric{"uback_last_run_read_bytes", "Bytes read from the source during the last run", float64(r.BytesRead)},
+			metric{"uback_last_run_written_bytes", "Bytes written to the destination during the last run", float64(r.BytesWritten)})
+	}
+	if r.Status == "success" && r.DestinationID != "" {
+		metrics = append(metrics,
+			metric{"uback_backups", "Number of backups on the destination", float64(r.Backups)},
+			metric{"uback_chain_length", "Number of backups needed to restore the most recent backup", float64(r.ChainLength)})
+	}
+	if r.Status == "success" {
+		metrics = append(metrics,
+			metric{"uback_last_run_pruned_backups", "Number of backups pruned during the last run", float64(len(r.PrunedBackups))},
+			metric{"uback_last_run_pruned_snapshots", "Number of snapshots pruned during the last run", float64(len(r.PrunedSnapshots))})
+	}
+
+	return metrics
+}
+
+// Write the metrics of a job to a node_exporter textfile collector file. If the job failed, the last
+// success timestamp of the previous run is kept.
+func writeMetricsTextfile(path string, r *jobReport) error {
+	labels := r.metricsLabels()
+	metrics := r.metrics()
+
+	buf := bytes.NewBuffer(nil)
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(buf, "# TYPE %s gauge\n", m.name)
+		fmt.Fprintf(buf, "%s%s %s\n", m.name, labels, strconv.FormatFloat(m.value, 'f', -1, 64))
+	}
+
+	if r.Status != "success" {
+		lastSuccess := previousMetric(path, "uback_last_success_timestamp_seconds"+labels)
+		if lastSuccess != "" {
+			fmt.Fprintf(buf, "# HELP uback_last_success_timestamp_seconds Start time of the last successful run\n")
+			fmt.Fprintf(buf, "# TYPE uback_last_success_timestamp_seconds gauge\n")
+			fmt.Fprintf(buf, "%s\n", lastSuccess)
+		}
+	}
+
+	// Write atomically, so that the collector never reads a partial file
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, buf.Bytes(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Return the line of a previously written metrics file starting with prefix
+func previousMetric(path string, prefix string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), prefix+" ") {
+			return scanner.Text()
+		}
+	}
+
+	return ""
+}
+
+func exportMetrics(r *jobReport) {
+	if metricsTextfile == "" {
+		return
+	}
+
+	err := writeMetricsTextfile(metricsTextfile, r)
+	if err != nil {
+		logrus.Warnf("cannot write metrics: %v", err)
+	}
+}
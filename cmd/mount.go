@@ -0,0 +1,589 @@
+package cmd
+
+import (
+	"github.com/sloonz/uback/container"
+	"github.com/sloonz/uback/lib"
+	"github.com/sloonz/uback/sources"
+
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"filippo.io/age"
+	gofs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var mountLog = logrus.WithFields(logrus.Fields{
+	"command": "mount",
+})
+
+// State shared by all the nodes of a mounted destination
+type mounter struct {
+	dst      uback.Destination
+	sk       []age.Identity
+	srcOpts  *uback.Options
+	cacheDir string
+	index    map[string]uback.Backup
+	fetchMu  sync.Mutex
+}
+
+// Copy a backup in the cache if it is not already there, and return the path of the cached copy
+func (m *mounter) fetch(b uback.Backup) (string, error) {
+	m.fetchMu.Lock()
+	defer m.fetchMu.Unlock()
+
+	p := path.Join(m.cacheDir, "backups", b.Filename())
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
+
+	err := os.MkdirAll(path.Dir(p), 0700)
+	if err != nil {
+		return "", err
+	}
+
+	mountLog.Printf("fetching %s", b.Filename())
+	data, err := m.dst.ReceiveBackup(b)
+	if err != nil {
+		return "", err
+	}
+	defer data.Close()
+
+	return p, writeCacheFile(p, data)
+}
+
+// Atomically write a file in the cache
+func writeCacheFile(p string, data io.Reader) error {
+	tmpPath := path.Join(path.Dir(p), "_tmp-"+path.Base(p))
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	_, err = io.Copy(f, data)
+	if err != nil {
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, p)
+}
+
+// Call f with the unsealed data of a cached backup
+func (m *mounter) open(b uback.Backup, f func(r *container.Reader) error) error {
+	p, err := m.fetch(b)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r, err := container.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	err = r.Unseal(m.sk)
+	if err != nil {
+		return err
+	}
+
+	return f(r)
+}
+
+// Return the source able to browse backups of the given type, if any
+func (m *mounter) browsableSource(typ string) (uback.BrowsableSource, bool) {
+	src, err := sources.NewForRestoration(m.srcOpts, typ)
+	if err != nil {
+		return nil, false
+	}
+
+	browsableSrc, ok := src.(uback.BrowsableSource)
+	return browsableSrc, ok
+}
+
+// Convert a file mode into a FUSE one
+func fuseMode(mode fs.FileMode) uint32 {
+	res := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		res |= syscall.S_ISUID
+	}
+	if mode&fs.ModeSetgid != 0 {
+		res |= syscall.S_ISGID
+	}
+	if mode&fs.ModeSticky != 0 {
+		res |= syscall.S_ISVTX
+	}
+
+	switch {
+	case mode.IsDir():
+		res |= syscall.S_IFDIR
+	case mode&fs.ModeSymlink != 0:
+		res |= syscall.S_IFLNK
+	default:
+		res |= syscall.S_IFREG
+	}
+
+	return res
+}
+
+func setAttr(out *fuse.Attr, mode fs.FileMode, size int64, mtime time.Time) {
+	out.Mode = fuseMode(mode)
+	out.Size = uint64(size)
+	out.SetTimes(nil, &mtime, &mtime)
+	out.Owner = fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+}
+
+// Root of the mounted filesystem, containing one directory per snapshot
+type mountRootNode struct {
+	gofs.Inode
+	m       *mounter
+	backups []uback.Backup
+}
+
+var _ = (gofs.NodeOnAdder)((*mountRootNode)(nil))
+
+func (n *mountRootNode) OnAdd(ctx context.Context) {
+	for _, b := range n.backups {
+		t, _ := b.Time()
+		child := &mountSnapshotNode{m: n.m, backup: b, mtime: t}
+		n.AddChild(b.Snapshot.Name(), n.NewPersistentInode(ctx, child, gofs.StableAttr{Mode: syscall.S_IFDIR}), false)
+	}
+}
+
+// Directory presenting a snapshot, whose content is only loaded when first accessed
+type mountSnapshotNode struct {
+	gofs.Inode
+	m      *mounter
+	backup uback.Backup
+	mtime  time.Time
+
+	once    sync.Once
+	loadErr error
+}
+
+var _ = (gofs.NodeGetattrer)((*mountSnapshotNode)(nil))
+var _ = (gofs.NodeLookuper)((*mountSnapshotNode)(nil))
+var _ = (gofs.NodeReaddirer)((*mountSnapshotNode)(nil))
+
+func (n *mountSnapshotNode) Getattr(ctx context.Context, fh gofs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setAttr(&out.Attr, fs.ModeDir|0555, 0, n.mtime)
+	return 0
+}
+
+func (n *mountSnapshotNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*gofs.Inode, syscall.Errno) {
+	if errno := n.load(ctx); errno != 0 {
+		return nil, errno
+	}
+
+	child := n.GetChild(name)
+	if child == nil {
+		return nil, syscall.ENOENT
+	}
+
+	var attrOut fuse.AttrOut
+	if getattrer, ok := child.Operations().(gofs.NodeGetattrer); ok {
+		getattrer.Getattr(ctx, nil, &attrOut)
+	}
+	out.Attr = attrOut.Attr
+	return child, 0
+}
+
+func (n *mountSnapshotNode) Readdir(ctx context.Context) (gofs.DirStream, syscall.Errno) {
+	if errno := n.load(ctx); errno != 0 {
+		return nil, errno
+	}
+
+	var entries []fuse.DirEntry
+	for name, child := range n.Children() {
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: child.Mode(), Ino: child.StableAttr().Ino})
+	}
+	return gofs.NewListDirStream(entries), 0
+}
+
+func (n *mountSnapshotNode) load(ctx context.Context) syscall.Errno {
+	n.once.Do(func() {
+		n.loadErr = n.doLoad(ctx)
+		if n.loadErr != nil {
+			mountLog.WithFields(logrus.Fields{"snapshot": n.backup.Snapshot.Name()}).Warnf("cannot load snapshot: %v", n.loadErr)
+		}
+	})
+	if n.loadErr != nil {
+		return syscall.EIO
+	}
+	return 0
+}
+
+func (n *mountSnapshotNode) doLoad(ctx context.Context) error {
+	chain, ok := uback.GetFullChain(n.backup, n.m.index)
+	if !ok {
+		return fmt.Errorf("the incremental backups chain do not reference a final full backup")
+	}
+
+	// Backups that cannot be browsed are presented as their raw data
+	var src uback.BrowsableSource
+	err := n.m.open(n.backup, func(r *container.Reader) error {
+		typ := r.Options.String["Type"]
+		var ok bool
+		src, ok = n.m.browsableSource(typ)
+		if !ok {
+			return n.addStream(ctx, typ, r)
+		}
+		return nil
+	})
+	if err != nil || src == nil {
+		return err
+	}
+
+	// Apply the listing of each backup of the chain, remembering which backup contains the
+	// current version of each file
+	entries := make(map[string]uback.BackupEntry)
+	owners := make(map[string]uback.Backup)
+	for i := len(chain) - 1; i >= 0; i-- {
+		b := chain[i]
+		err := n.m.open(b, func(r *container.Reader) error {
+			baseEntries := make(map[string]uback.BackupEntry, len(entries))
+			for name, e := range entries {
+				baseEntries[name] = e
+			}
+
+			err := src.ListBackupEntries(entries, b, r)
+			if err != nil {
+				return err
+			}
+
+			for name, e := range entries {
+				if baseEntry, ok := baseEntries[name]; !ok || baseEntry != e {
+					owners[name] = b
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedEntryNames(entries) {
+		n.addEntry(ctx, entries, owners, name)
+	}
+
+	return nil
+}
+
+// Present the raw data of a backup that cannot be browsed, decrypted in the cache
+func (n *mountSnapshotNode) addStream(ctx context.Context, typ string, data io.Reader) error {
+	name := n.backup.FullName()
+	p := path.Join(n.m.cacheDir, "streams", name)
+	if _, err := os.Stat(p); err != nil {
+		err = os.MkdirAll(path.Dir(p), 0700)
+		if err != nil {
+			return err
+		}
+
+		err = writeCacheFile(p, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if typ != "" {
+		name += "." + strings.ReplaceAll(typ, "/", "_")
+	}
+
+	file := &mountFileNode{mode: 0444, size: fi.Size(), mtime: n.mtime, contentPath: p}
+	n.AddChild(name, n.NewPersistentInode(ctx, file, gofs.StableAttr{Mode: syscall.S_IFREG}), false)
+	return nil
+}
+
+func (n *mountSnapshotNode) addEntry(ctx context.Context, entries map[string]uback.BackupEntry, owners map[string]uback.Backup, name string) {
+	e := entries[name]
+
+	parent := &n.Inode
+	if dir := path.Dir(name); dir != "." {
+		parent = n.GetChild(strings.Split(dir, "/")[0])
+		for _, component := range strings.Split(dir, "/")[1:] {
+			if parent == nil {
+				break
+			}
+			parent = parent.GetChild(component)
+		}
+		if parent == nil {
+			// Parent directory is not in the backup, should not happen
+			return
+		}
+	}
+
+	var child *gofs.Inode
+	switch {
+	case e.Mode.IsDir():
+		child = parent.NewPersistentInode(ctx, &mountDirNode{entry: e}, gofs.StableAttr{Mode: syscall.S_IFDIR})
+
+	case e.Mode&fs.ModeSymlink != 0:
+		symlink := &gofs.MemSymlink{Data: []byte(e.Linkname)}
+		setAttr(&symlink.Attr, e.Mode, int64(len(e.Linkname)), e.ModTime)
+		child = parent.NewPersistentInode(ctx, symlink, gofs.StableAttr{Mode: syscall.S_IFLNK})
+
+	default:
+		// Hard links share the content of their target
+		target := e
+		if e.Linkname != "" {
+			if linked, ok := entries[cleanEntryPath(e.Linkname)]; ok {
+				target = linked
+			}
+		}
+		file := &mountFileNode{m: n.m, mode: e.Mode, size: target.Size, mtime: e.ModTime, path: target.Path, owner: owners[target.Path]}
+		child = parent.NewPersistentInode(ctx, file, gofs.StableAttr{Mode: syscall.S_IFREG})
+	}
+
+	parent.AddChild(path.Base(name), child, false)
+}
+
+type mountDirNode struct {
+	gofs.Inode
+	entry uback.BackupEntry
+}
+
+var _ = (gofs.NodeGetattrer)((*mountDirNode)(nil))
+
+func (n *mountDirNode) Getattr(ctx context.Context, fh gofs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setAttr(&out.Attr, n.entry.Mode, 0, n.entry.ModTime)
+	return 0
+}
+
+// A regular file, whose content is extracted into the cache when first opened
+type mountFileNode struct {
+	gofs.Inode
+	m     *mounter
+	mode  fs.FileMode
+	size  int64
+	mtime time.Time
+
+	// Path of the file in the backup, and backup containing its content
+	path  string
+	owner uback.Backup
+
+	mu          sync.Mutex
+	contentPath string
+}
+
+var _ = (gofs.NodeGetattrer)((*mountFileNode)(nil))
+var _ = (gofs.NodeOpener)((*mountFileNode)(nil))
+
+func (n *mountFileNode) Getattr(ctx context.Context, fh gofs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	setAttr(&out.Attr, n.mode, n.size, n.mtime)
+	return 0
+}
+
+func (n *mountFileNode) Open(ctx context.Context, flags uint32) (gofs.FileHandle, uint32, syscall.Errno) {
+	if flags&(syscall.O_WRONLY|syscall.O_RDWR) != 0 {
+		return nil, 0, syscall.EROFS
+	}
+
+	p, err := n.extract()
+	if err != nil {
+		mountLog.WithFields(logrus.Fields{"file": n.path}).Warnf("cannot extract file: %v", err)
+		return nil, 0, syscall.EIO
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, 0, gofs.ToErrno(err)
+	}
+
+	return gofs.NewLoopbackFileFromOS(f), fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// Extract the content of the file from its backup into the cache
+func (n *mountFileNode) extract() (string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.contentPath != "" {
+		return n.contentPath, nil
+	}
+
+	// The backup is restored as if it was a full backup, so that the extraction does not depend
+	// on its base
+	targetDir, err := os.MkdirTemp(path.Join(n.m.cacheDir, "files"), "")
+	if err != nil {
+		return "", err
+	}
+
+	b := uback.Backup{Snapshot: n.owner.Snapshot}
+	err = n.m.open(n.owner, func(r *container.Reader) error {
+		src, ok := n.m.browsableSource(r.Options.String["Type"])
+		if !ok {
+			return ErrNotBrowsable
+		}
+		return src.RestorePartialBackup(targetDir, b, r, []string{escapeGlob(n.path)})
+	})
+	if err != nil {
+		os.RemoveAll(targetDir)
+		return "", err
+	}
+
+	p := path.Join(targetDir, b.Snapshot.Name(), n.path)
+	if _, err := os.Lstat(p); err != nil {
+		os.RemoveAll(targetDir)
+		return "", err
+	}
+
+	// The content is served by us, make sure we can read it whatever its restored mode is
+	err = os.Chmod(p, 0400)
+	if err != nil {
+		return "", err
+	}
+
+	n.contentPath = p
+	return p, nil
+}
+
+// Escape a path so that it only matches itself as a glob pattern
+func escapeGlob(p string) string {
+	var sb strings.Builder
+	for _, c := range p {
+		if strings.ContainsRune(`*?[\`, c) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// Normalize a path found in an archive like uback.BackupEntry paths
+func cleanEntryPath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// Mount the backups of a destination until the filesystem is unmounted. Errors are returned rather
+// than fatal, so that the temporary cache directory is always removed
+func runMount(dstOpts *optionsBuilder, srcOpts *uback.Options, backups []uback.Backup, mountpoint string) error {
+	cacheDir := cmdMountCacheDir
+	if cacheDir == "" {
+		var err error
+		cacheDir, err = os.MkdirTemp("", "uback-mount-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(cacheDir)
+	}
+
+	// Extracted files and streams are decrypted, and only kept for the duration of the mount
+	// (including those left by a mount that was not cleanly stopped) ; fetched backups are
+	// still encrypted and kept
+	for _, dir := range []string{"files", "streams"} {
+		err := os.RemoveAll(path.Join(cacheDir, dir))
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(path.Join(cacheDir, dir))
+	}
+
+	err := os.MkdirAll(path.Join(cacheDir, "files"), 0700)
+	if err != nil {
+		return err
+	}
+
+	m := &mounter{
+		dst:      dstOpts.Destination,
+		sk:       dstOpts.Identities,
+		srcOpts:  srcOpts,
+		cacheDir: cacheDir,
+		index:    uback.MakeIndex(backups),
+	}
+
+	timeout := time.Hour
+	server, err := gofs.Mount(mountpoint, &mountRootNode{m: m, backups: backups}, &gofs.Options{
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		MountOptions: fuse.MountOptions{
+			FsName:      "uback",
+			Name:        "uback",
+			Options:     []string{"ro"},
+			DirectMount: true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		<-sigs
+		err := server.Unmount()
+		if err != nil {
+			mountLog.Warnf("cannot unmount: %v", err)
+		}
+	}()
+
+	mountLog.Printf("mounted on %s", mountpoint)
+	server.Wait()
+
+	return nil
+}
+
+var (
+	cmdMountSourceOptions string
+	cmdMountCacheDir      string
+	cmdMount              = &cobra.Command{
+		Use:   "mount <destination> <mountpoint>",
+		Short: "Mount the backups of a destination as a read-only filesystem",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			dstOpts := newOptionsBuilder(uback.EvalOptions(uback.SplitOptions(args[0]), presets)).
+				WithDestination().
+				WithIdentities().
+				FatalOnError()
+
+			srcOpts, err := uback.EvalOptions(uback.SplitOptions(cmdMountSourceOptions), presets)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
+			backups, err := uback.SortedListBackups(dstOpts.Destination)
+			if err != nil {
+				logrus.Fatal(err)
+			}
+
+			err = runMount(dstOpts, srcOpts, backups, args[1])
+			if err != nil {
+				logrus.Fatal(err)
+			}
+		},
+	}
+)
+
+func init() {
+	cmdMount.Flags().StringVarP(&cmdMountSourceOptions, "source-options", "o", "", "additional source options")
+	cmdMount.Flags().StringVarP(&cmdMountCacheDir, "cache-dir", "c", "", "directory where fetched backups are kept (default: temporary directory)")
+}
@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"github.com/sloonz/uback/lib"
+
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotifyOn = errors.New("invalid NotifyOn option: must be always, failure or success")
+	notifyLog   = logrus.WithFields(logrus.Fields{
+		"component": "notify",
+	})
+	notifyHTTPTimeout = 30 * time.Second
+)
+
+// Report of a backup or prune operation, sent to notifiers
+type jobReport struct {
+	Job             string    `json:"job"`
+	Command         string    `json:"command"`
+	Status          string    `json:"status"`
+	Error           string    `json:"error,omitempty"`
+	SourceType      string    `json:"sourceType,omitempty"`
+	DestinationType string    `json:"destinationType,omitempty"`
+	DestinationID   string    `json:"destinationId,omitempty"`
+	Backup          string    `json:"backup,omitempty"`
+	BytesRead       int64     `json:"bytesRead"`
+	BytesWritten    int64     `json:"bytesWritten"`
+	Backups         int       `json:"backups,omitempty"`
+	ChainLength     int       `json:"chainLength,omitempty"`
+	PrunedBackups   []string  `json:"prunedBackups,omitempty"`
+	PrunedSnapshots []string  `json:"prunedSnapshots,omitempty"`
+	StartedAt       time.Time `json:"startedAt"`
+	Duration        float64   `json:"duration"`
+
+	bytesRead    *countingReader
+	bytesWritten *countingReader
+}
+
+func newJobReport(command string, srcOpts *uback.Options, srcType string, dstOpts *uback.Options) *jobReport {
+	r := &jobReport{Command: command, SourceType: srcType, StartedAt: time.Now()}
+	if dstOpts != nil {
+		r.DestinationType = dstOpts.String["Type"]
+		r.DestinationID = dstOpts.String["ID"]
+	}
+	for _, opts := range []*uback.Options{dstOpts, srcOpts} {
+		if opts != nil && r.Job == "" {
+			r.Job = opts.String["JobName"]
+		}
+	}
+	if r.Job == "" {
+		r.Job = strings.TrimSpace(fmt.Sprintf("%s %s %s", command, srcType, r.DestinationID))
+	}
+	return r
+}
+
+// Record the number of backups remaining on the destination (backups minus pruned backups), and the
+// length of the chain of the most recent one. backups must be sorted, most recent first.
+func (r *jobReport) setBackups(backups []uback.Backup) {
+	pruned := make(map[string]bool)
+	for _, name := range r.PrunedBackups {
+		pruned[name] = true
+	}
+
+	var remaining []uback.Backup
+	for _, b := range backups {
+		if !pruned[b.FullName()] {
+			remaining = append(remaining, b)
+		}
+	}
+
+	r.Backups = len(remaining)
+	r.ChainLength = 0
+	if len(remaining) > 0 {
+		chain, _ := uback.GetFullChain(remaining[0], uback.MakeIndex(remaining))
+		r.ChainLength = len(chain)
+	}
+}
+
+// Record the outcome of the job
+func (r *jobReport) finish(err error) {
+	r.Duration = time.Since(r.StartedAt).Seconds()
+	if r.bytesRead != nil {
+		r.BytesRead = r.bytesRead.count()
+	}
+	if r.bytesWritten != nil {
+		r.BytesWritten = r.bytesWritten.count()
+	}
+	if err == nil {
+		r.Status = "success"
+	} else {
+		r.Status = "failure"
+		r.Error = err.Error()
+	}
+}
+
+func (r *jobReport) summary() string {
+	s := fmt.Sprintf("uback: %s %s", r.Job, r.Status)
+	if r.Backup != "" {
+		s += " (" + r.Backup + ")"
+	}
+	return s
+}
+
+func (r *jobReport) text() string {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "Job: %s\n", r.Job)
+	fmt.Fprintf(buf, "Command: %s\n", r.Command)
+	fmt.Fprintf(buf, "Status: %s\n", r.Status)
+	if r.Error != "" {
+		fmt.Fprintf(buf, "Error: %s\n", r.Error)
+	}
+	if r.SourceType != "" {
+		fmt.Fprintf(buf, "Source type: %s\n", r.SourceType)
+	}
+	if r.DestinationID != "" {
+		fmt.Fprintf(buf, "Destination: %s\n", r.DestinationID)
+	}
+	if r.Backup != "" {
+		fmt.Fprintf(buf, "Backup: %s\n", r.Backup)
+	}
+	if r.Command == "backup" {
+		fmt.Fprintf(buf, "Bytes read: %d\n", r.BytesRead)
+		fmt.Fprintf(buf, "Bytes written: %d\n", r.BytesWritten)
+	}
+	if len(r.PrunedBackups) > 0 {
+		fmt.Fprintf(buf, "Pruned backups: %s\n", strings.Join(r.PrunedBackups, ", "))
+	}
+	if len(r.PrunedSnapshots) > 0 {
+		fmt.Fprintf(buf, "Pruned snapshots: %s\n", strings.Join(r.PrunedSnapshots, ", "))
+	}
+	fmt.Fprintf(buf, "Started at: %s\n", r.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(buf, "Duration: %.3fs\n", r.Duration)
+	return buf.String()
+}
+
+// Count the bytes read through a reader
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
+
+func (cr *countingReader) count() int64 {
+	return cr.n.Load()
+}
+
+// Send the report of a job to the notifiers configured in each set of options (NotifyURL,
+// @NotifyCommand and NotifyEmail). Notification failures are only logged.
+func notify(report *jobReport, optionsSets ...*uback.Options) {
+	payload, err := json.Marshal(report)
+	if err != nil {
+		notifyLog.Warnf("cannot encode report: %v", err)
+		return
+	}
+
+	for _, options := range optionsSets {
+		if options == nil {
+			continue
+		}
+
+		switch options.GetString("NotifyOn", "always") {
+		case "always":
+		case "failure":
+			if report.Status != "failure" {
+				continue
+			}
+		case "success":
+			if report.Status != "success" {
+				continue
+			}
+		default:
+			notifyLog.Warn(ErrNotifyOn)
+			continue
+		}
+
+		if url := options.String["NotifyURL"]; url != "" {
+			err = notifyURL(url, payload)
+			if err != nil {
+				notifyLog.Warnf("cannot notify %s: %v", url, err)
+			}
+		}
+
+		if command := options.GetCommand("NotifyCommand", nil); len(command) > 0 {
+			err = notifyCommand(command, report, payload)
+			if err != nil {
+				notifyLog.Warnf("cannot run notification command: %v", err)
+			}
+		}
+
+		if to := options.String["NotifyEmail"]; to != "" {
+			err = notifyEmail(options, to, report)
+			if err != nil {
+				notifyLog.Warnf("cannot send notification email: %v", err)
+			}
+		}
+	}
+}
+
+// POST the JSON report to a webhook
+func notifyURL(url string, payload []byte) error {
+	client := &http.Client{Timeout: notifyHTTPTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Run a command with the JSON report on its standard input
+func notifyCommand(command []string, report *jobReport, payload []byte) error {
+	cmd := uback.BuildCommand(command)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"UBACK_JOB="+report.Job,
+		"UBACK_COMMAND="+report.Command,
+		"UBACK_STATUS="+report.Status,
+		"UBACK_ERROR="+report.Error,
+		"UBACK_BACKUP="+report.Backup)
+	return uback.RunCommand(notifyLog, cmd)
+}
+
+// Send the report by email using a local sendmail binary
+func notifyEmail(options *uback.Options, to string, report *jobReport) error {
+	msg := bytes.NewBuffer(nil)
+	if from := options.String["NotifyEmailFrom"]; from != "" {
+		fmt.Fprintf(msg, "From: %s\n", from)
+	}
+	fmt.Fprintf(msg, "To: %s\n", to)
+	fmt.Fprintf(msg, "Subject: %s\n", report.summary())
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\n")
+	fmt.Fprintf(msg, "\n")
+	msg.WriteString(report.text())
+
+	cmd := uback.BuildCommand(options.GetCommand("SendmailCommand", []string{"sendmail", "-t", "-i"}))
+	cmd.Stdin = msg
+	return uback.RunCommand(notifyLog, cmd)
+}
@@ -19,6 +19,7 @@ type optionsBuilder struct {
 	SourceType        string
 	Destination       uback.Destination
 	RetentionPolicies []uback.RetentionPolicy
+	MaxSize           int64
 	Identities        []age.Identity
 	Recipients        []age.Recipient
 	Error             error
@@ -88,6 +89,19 @@ func (o *optionsBuilder) WithRetentionPolicies() *optionsBuilder {
 	return o
 }
 
+func (o *optionsBuilder) WithMaxSize() *optionsBuilder {
+	if o.Error == nil && o.Options.String["MaxSize"] != "" {
+		o.MaxSize, o.Error = uback.ParseSize(o.Options.String["MaxSize"])
+		if o.Error == nil && o.MaxSize == 0 {
+			// A zero budget would silently disable the size budget instead of pruning everything
+			o.Error = fmt.Errorf("invalid MaxSize: %s", o.Options.String["MaxSize"])
+		} else if _, ok := o.Destination.(uback.SizedDestination); o.Error == nil && !ok {
+			o.Error = fmt.Errorf("MaxSize is not supported by this destination")
+		}
+	}
+	return o
+}
+
 func (o *optionsBuilder) FatalOnError() *optionsBuilder {
 	if o.Error != nil {
 		logrus.Fatal(o.Error)
@@ -9,6 +9,7 @@ import (
 	"net/rpc"
 	"os"
 
+	"filippo.io/age"
 	"github.com/hashicorp/yamux"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -140,7 +141,23 @@ func (s *Source) CreateBackup(args *sources.CreateBackupArgs, reply *uback.Backu
 	}
 
 	*reply, s.backup, err = srcOpts.Source.CreateBackup(args.Snapshot)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if ss, ok := srcOpts.Source.(uback.SealedSource); args.Seal && !(ok && ss.IsSealed()) {
+		var recipients []age.Recipient
+		if args.Recipients != "" {
+			recipients, err = uback.LoadRecipients("", args.Recipients)
+			if err != nil {
+				_ = s.backup.Close()
+				return err
+			}
+		}
+		s.backup = sealBackup(s.backup, recipients, srcOpts.SourceType, defaultCompressionLevel)
+	}
+
+	return nil
 }
 
 func (s *Source) TransmitBackup(args *struct{}, reply *struct{}) error {
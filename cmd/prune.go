@@ -6,12 +6,117 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
-var cmdPruneBackupsDryRun bool
+// Why a backup or a snapshot is kept or pruned
+func retentionReasons(e uback.RetentionExplanation) string {
+	if len(e.Reasons) == 0 {
+		return "not retained by any retention policy"
+	}
+	return strings.Join(e.Reasons, ", ")
+}
+
+// Print, for each item, whether it is kept or pruned and why
+func printRetentionExplanations(kind string, explanations []uback.RetentionExplanation) {
+	for _, e := range explanations {
+		action := "prune"
+		if e.Retained {
+			action = "keep"
+		}
+		reasons := retentionReasons(e)
+		if kind != "" {
+			fmt.Printf("%s\t%s\t%s\t%s\n", kind, e.Name, action, reasons)
+		} else {
+			fmt.Printf("%s\t%s\t%s\n", e.Name, action, reasons)
+		}
+	}
+}
+
+// Log, for each chain of retained backups, how many backups (and bytes, if the destination can
+// report the size of backups) are only kept as dependencies of other backups
+func logChainCosts(dst uback.Destination, backups []uback.Backup, explanations []uback.RetentionExplanation) error {
+	var sizes map[string]int64
+	if _, ok := dst.(uback.SizedDestination); ok {
+		var err error
+		sizes, err = uback.ListBackupSizes(dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range uback.GetChainCosts(backups, explanations, sizes) {
+		fields := logrus.Fields{"chain": c.Root.FullName(), "backups": c.Backups, "dependencies": c.Dependencies}
+		if sizes != nil {
+			fields["size"] = uback.FormatSize(c.Size)
+			fields["dependencies-size"] = uback.FormatSize(c.DependenciesSize)
+		}
+		logrus.WithFields(fields).Info("chain cost")
+	}
+
+	return nil
+}
+
+// Remove the backups of a destination that are not retained by its retention policies and size budget
+func pruneBackups(dstOpts *optionsBuilder, report *jobReport) error {
+	allBackups, err := uback.SortedListBackups(dstOpts.Destination)
+	if err != nil {
+		return err
+	}
+
+	// Only count the backups that are still there once pruned
+	defer report.setBackups(allBackups)
+
+	if !cmdPruneBackupsExplain && len(dstOpts.RetentionPolicies) == 0 && dstOpts.MaxSize == 0 {
+		logrus.Warn("no retention policies set for destination, keeping everything")
+		return nil
+	}
+
+	explanations, _, err := uback.ExplainDestinationPruning(dstOpts.Destination, allBackups, dstOpts.RetentionPolicies, dstOpts.MaxSize)
+	if err != nil {
+		return err
+	}
+
+	if cmdPruneBackupsExplain {
+		printRetentionExplanations("", explanations)
+		return nil
+	}
+
+	if !cmdPruneBackupsNoChainCosts {
+		err = logChainCosts(dstOpts.Destination, allBackups, explanations)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, b := range allBackups {
+		if explanations[i].Retained {
+			continue
+		}
+		fmt.Println(string(b.Snapshot))
+		if cmdPruneBackupsDryRun {
+			continue
+		}
+		err = uback.RemoveBackup(dstOpts.Destination, b)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"backup": string(b.Snapshot)}).Warnf("cannot remove backup: %v", err)
+			continue
+		}
+		report.PrunedBackups = append(report.PrunedBackups, b.FullName())
+	}
+
+	return nil
+}
+
+var (
+	cmdPruneBackupsDryRun       bool
+	cmdPruneBackupsExplain      bool
+	cmdPruneBackupsNoChainCosts bool
+)
+
 var cmdPruneBackups = &cobra.Command{
 	Use:   "backups <destination>",
 	Short: "Prune backups on a destination",
@@ -20,31 +125,105 @@ var cmdPruneBackups = &cobra.Command{
 		dstOpts := newOptionsBuilder(uback.EvalOptions(uback.SplitOptions(args[0]), presets)).
 			WithDestination().
 			WithRetentionPolicies().
+			WithMaxSize().
 			FatalOnError()
 
-		allBackups, err := uback.SortedListBackups(dstOpts.Destination)
+		// Read-only runs neither notify nor export metrics
+		readOnly := cmdPruneBackupsDryRun || cmdPruneBackupsExplain
+
+		report := newJobReport("prune backups", nil, "", dstOpts.Options)
+		err := pruneBackups(dstOpts, report)
+		report.finish(err)
+		if !readOnly {
+			notify(report, dstOpts.Options)
+			exportMetrics(report)
+		}
 		if err != nil {
 			logrus.Fatal(err)
 		}
+	},
+}
 
-		prunedBackups, err := uback.GetPrunedBackups(allBackups, dstOpts.RetentionPolicies)
-		if err != nil {
-			logrus.Fatal(err)
+// Remove the snapshots of a source that are not retained by its retention policies
+func pruneSnapshots(srcOpts *optionsBuilder, report *jobReport) error {
+	archives, err := uback.SortedListArchives(srcOpts.Source)
+	if err != nil {
+		return err
+	}
+
+	bookmarks, err := uback.SortedListBookmarks(srcOpts.Source)
+	if err != nil {
+		return err
+	}
+
+	state := make(map[string]string)
+	if srcOpts.Options.String["StateFile"] != "" {
+		rawState, err := os.ReadFile(srcOpts.Options.String["StateFile"])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		} else if err != nil {
+			logrus.Warn("state file does not exists yet ; this is probably a configuration mistake, forcing --dry-run")
+			cmdPruneSnapshotsDryRun = true
 		}
 
-		for _, b := range prunedBackups {
-			fmt.Println(string(b.Snapshot))
-			if !cmdPruneBackupsDryRun {
-				err = dstOpts.Destination.RemoveBackup(b)
-				if err != nil {
-					logrus.WithFields(logrus.Fields{"backup": string(b.Snapshot)}).Warnf("cannot remove backup: %v", err)
-				}
+		if rawState != nil {
+			err = json.Unmarshal(rawState, &state)
+			if err != nil {
+				return err
 			}
 		}
-	},
+	}
+
+	if cmdPruneSnapshotsExplain {
+		archivesExplanations, bookmarksExplanations, err := uback.ExplainPrunedSnapshots(archives, bookmarks, srcOpts.RetentionPolicies, state)
+		if err != nil {
+			return err
+		}
+
+		printRetentionExplanations("archive", archivesExplanations)
+		printRetentionExplanations("bookmark", bookmarksExplanations)
+		return nil
+	}
+
+	prunedArchives, prunedBookmarks, err := uback.GetPrunedSnapshots(archives, bookmarks, srcOpts.RetentionPolicies, state)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range prunedArchives {
+		fmt.Println(string(s))
+		if cmdPruneSnapshotsDryRun {
+			continue
+		}
+		err = srcOpts.Source.RemoveArchive(s)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"archive": string(s)}).Warnf("cannot remove archive: %v", err)
+			continue
+		}
+		report.PrunedSnapshots = append(report.PrunedSnapshots, string(s))
+	}
+
+	for _, s := range prunedBookmarks {
+		fmt.Println(string(s))
+		if cmdPruneSnapshotsDryRun {
+			continue
+		}
+		err = srcOpts.Source.RemoveBookmark(s)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"bookmark": string(s)}).Warnf("cannot remove bookmark: %v", err)
+			continue
+		}
+		report.PrunedSnapshots = append(report.PrunedSnapshots, string(s))
+	}
+
+	return nil
 }
 
-var cmdPruneSnapshotsDryRun bool
+var (
+	cmdPruneSnapshotsDryRun  bool
+	cmdPruneSnapshotsExplain bool
+)
+
 var cmdPruneSnapshots = &cobra.Command{
 	Use:   "snapshots <source>",
 	Short: "Prune snapshots on a source",
@@ -56,58 +235,20 @@ var cmdPruneSnapshots = &cobra.Command{
 			WithStateFile().
 			FatalOnError()
 
-		archives, err := uback.SortedListArchives(srcOpts.Source)
-		if err != nil {
-			logrus.Fatal(err)
-		}
-
-		bookmarks, err := uback.SortedListBookmarks(srcOpts.Source)
-		if err != nil {
-			logrus.Fatal(err)
-		}
-
-		state := make(map[string]string)
-		if srcOpts.Options.String["StateFile"] != "" {
-			rawState, err := os.ReadFile(srcOpts.Options.String["StateFile"])
-			if err != nil && !os.IsNotExist(err) {
-				logrus.Fatal(err)
-			} else if err != nil {
-				logrus.Warn("state file does not exists yet ; this is probably a configuration mistake, forcing --dry-run")
-				cmdPruneSnapshotsDryRun = true
-			}
+		// Read-only runs neither notify nor export metrics ; a dry run forced by a missing state
+		// file still does, since it is probably a misconfigured scheduled job
+		readOnly := cmdPruneSnapshotsDryRun || cmdPruneSnapshotsExplain
 
-			if rawState != nil {
-				err = json.Unmarshal(rawState, &state)
-				if err != nil {
-					logrus.Fatal(err)
-				}
-			}
+		report := newJobReport("prune snapshots", srcOpts.Options, srcOpts.SourceType, nil)
+		err := pruneSnapshots(srcOpts, report)
+		report.finish(err)
+		if !readOnly {
+			notify(report, srcOpts.Options)
+			exportMetrics(report)
 		}
-
-		prunedArchives, prunedBookmarks, err := uback.GetPrunedSnapshots(archives, bookmarks, srcOpts.RetentionPolicies, state)
 		if err != nil {
 			logrus.Fatal(err)
 		}
-
-		for _, s := range prunedArchives {
-			fmt.Println(string(s))
-			if !cmdPruneSnapshotsDryRun {
-				err = srcOpts.Source.RemoveArchive(s)
-				if err != nil {
-					logrus.WithFields(logrus.Fields{"archive": string(s)}).Warnf("cannot remove archive: %v", err)
-				}
-			}
-		}
-
-		for _, s := range prunedBookmarks {
-			fmt.Println(string(s))
-			if !cmdPruneSnapshotsDryRun {
-				err = srcOpts.Source.RemoveBookmark(s)
-				if err != nil {
-					logrus.WithFields(logrus.Fields{"bookmark": string(s)}).Warnf("cannot remove bookmark: %v", err)
-				}
-			}
-		}
 	},
 }
 
@@ -118,5 +259,10 @@ var cmdPrune = &cobra.Command{
 func init() {
 	cmdPruneBackups.Flags().BoolVarP(&cmdPruneBackupsDryRun, "dry-run", "n", false, "do not actually remove anything, just prints backups that would be removed")
 	cmdPruneSnapshots.Flags().BoolVarP(&cmdPruneSnapshotsDryRun, "dry-run", "n", false, "do not actually remove anything, just prints snapshots that would be removed")
+	cmdPruneBackups.Flags().BoolVarP(&cmdPruneBackupsExplain, "explain", "e", false, "do not remove anything, print whether each backup is kept or pruned and why")
+	cmdPruneBackups.Flags().BoolVar(&cmdPruneBackupsNoChainCosts, "no-chain-costs", false, "do not log for each chain of retained backups how many backups are only kept as dependencies")
+	cmdPruneSnapshots.Flags().BoolVarP(&cmdPruneSnapshotsExplain, "explain", "e", false, "do not remove anything, print whether each snapshot is kept or pruned and why")
+	cmdPruneBackups.Flags().StringVar(&metricsTextfile, "metrics-textfile", "", "write Prometheus metrics to this file (node_exporter textfile collector format)")
+	cmdPruneSnapshots.Flags().StringVar(&metricsTextfile, "metrics-textfile", "", "write Prometheus metrics to this file (node_exporter textfile collector format)")
 	cmdPrune.AddCommand(cmdPruneSnapshots, cmdPruneBackups)
 }
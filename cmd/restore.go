@@ -5,6 +5,7 @@ import (
 	"github.com/sloonz/uback/lib"
 	"github.com/sloonz/uback/sources"
 
+	"errors"
 	"io"
 	"os"
 	"path"
@@ -15,18 +16,18 @@ import (
 	"github.com/spf13/cobra"
 )
 
-func restore(dst uback.Destination, b uback.Backup, sk []age.Identity, targetDir string) error {
-	logrus.Printf("restoring %v onto %v", b.Filename(), targetDir)
-
-	srcOpts, err := uback.EvalOptions(uback.SplitOptions(cmdRestoreSourceOptions), presets)
-	if err != nil {
-		logrus.Fatal(err)
-	}
-
+// Open a backup, from localDir if it is not empty and the backup has already been fetched there,
+// or else from the destination, and call f with the source able to restore it and the unsealed
+// backup data
+func withBackup(dst uback.Destination, b uback.Backup, sk []age.Identity, srcOpts *uback.Options, localDir string, f func(src uback.Source, data io.Reader) error) error {
 	var data io.ReadCloser
-	if f, err := os.Open(path.Join(targetDir, b.Filename())); err == nil {
-		data = f
-	} else {
+	if localDir != "" {
+		if file, err := os.Open(path.Join(localDir, b.Filename())); err == nil {
+			data = file
+		}
+	}
+	if data == nil {
+		var err error
 		data, err = dst.ReceiveBackup(b)
 		if err != nil {
 			return err
@@ -50,18 +51,86 @@ func restore(dst uback.Destination, b uback.Backup, sk []age.Identity, targetDir
 		return err
 	}
 
-	err = src.RestoreBackup(targetDir, b, r)
+	return f(src, r)
+}
+
+// Find the first backup of backups whose full name starts with name
+func findBackup(backups []uback.Backup, name string) (uback.Backup, error) {
+	for _, b := range backups {
+		if strings.HasPrefix(b.FullName(), name) {
+			return b, nil
+		}
+	}
+	return uback.Backup{}, errors.New("cannot find backup")
+}
+
+// Find the most recent backup whose full name starts with name, and the chain of backups needed to
+// restore it (starting from the backup itself)
+func findBackupChain(dst uback.Destination, name string) ([]uback.Backup, error) {
+	backups, err := uback.SortedListBackups(dst)
+	if err != nil {
+		return nil, err
+	}
+
+	targetBackup, err := findBackup(backups, name)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, ok := uback.GetFullChain(targetBackup, uback.MakeIndex(backups))
+	if !ok {
+		return nil, errors.New("the incremental backups chain do not reference a final full backup")
+	}
+
+	return chain, nil
+}
+
+func restore(dst uback.Destination, b uback.Backup, sk []age.Identity, targetDir string, patterns []string) error {
+	logrus.Printf("restoring %v onto %v", b.Filename(), targetDir)
+
+	srcOpts, err := uback.EvalOptions(uback.SplitOptions(cmdRestoreSourceOptions), presets)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	return withBackup(dst, b, sk, srcOpts, targetDir, func(src uback.Source, data io.Reader) error {
+		if len(patterns) == 0 {
+			return src.RestoreBackup(targetDir, b, data)
+		}
+
+		browsableSrc, ok := src.(uback.BrowsableSource)
+		if !ok {
+			return ErrNotBrowsable
+		}
+		return browsableSrc.RestorePartialBackup(targetDir, b, data, patterns)
+	})
+}
+
+// Restore the backup matching targetName, with all the backups it depends on
+func runRestore(dstOpts *optionsBuilder, targetName string, h *hooks) error {
+	fetchedBackups, err := findBackupChain(dstOpts.Destination, targetName)
 	if err != nil {
 		return err
 	}
+	h.setBackup(fetchedBackups[0])
+
+	for i := len(fetchedBackups) - 1; i >= 0; i-- {
+		err = restore(dstOpts.Destination, fetchedBackups[i], dstOpts.Identities, cmdRestoreTargetDir, cmdRestorePaths)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 var (
+	ErrNotBrowsable = errors.New("backups of this type cannot be browsed nor partially restored")
+
 	cmdRestoreTargetDir     string
 	cmdRestoreSourceOptions string
 	cmdRestoreUseLocal      bool
+	cmdRestorePaths         []string
 	cmdRestore              = &cobra.Command{
 		Use:   "restore <dest> [backup-name]",
 		Short: "Restore a backup",
@@ -77,32 +146,25 @@ var (
 				WithIdentities().
 				FatalOnError()
 
-			backups, err := uback.SortedListBackups(dstOpts.Destination)
+			srcOpts, err := uback.EvalOptions(uback.SplitOptions(cmdRestoreSourceOptions), presets)
 			if err != nil {
 				logrus.Fatal(err)
 			}
 
-			var targetBackup *uback.Backup
-			for i, b := range backups {
-				if strings.HasPrefix(b.FullName(), targetName) {
-					targetBackup = &backups[i]
-					break
-				}
-			}
-			if targetBackup == nil {
-				logrus.Fatal("cannot find backup")
+			h, err := newHooks("restore", srcOpts, "", dstOpts.Options)
+			if err != nil {
+				logrus.Fatal(err)
 			}
+			h.env = append(h.env, "UBACK_TARGET_DIR="+cmdRestoreTargetDir)
 
-			fetchedBackups, ok := uback.GetFullChain(*targetBackup, uback.MakeIndex(backups))
-			if !ok {
-				logrus.Fatal("the incremental backups chain do not reference a final full backup")
+			err = h.run("PreCommand")
+			if err == nil {
+				err = runRestore(dstOpts, targetName, h)
 			}
 
-			for i := len(fetchedBackups) - 1; i >= 0; i-- {
-				err = restore(dstOpts.Destination, fetchedBackups[i], dstOpts.Identities, cmdRestoreTargetDir)
-				if err != nil {
-					logrus.Fatal(err)
-				}
+			err = h.finish(err)
+			if err != nil {
+				logrus.Fatal(err)
 			}
 		},
 	}
@@ -112,4 +174,5 @@ func init() {
 	cmdRestore.Flags().StringVarP(&cmdRestoreTargetDir, "target-dir", "d", ".", "target dir")
 	cmdRestore.Flags().StringVarP(&cmdRestoreSourceOptions, "source-options", "o", ".", "additional source options")
 	cmdRestore.Flags().BoolVarP(&cmdRestoreUseLocal, "local", "l", false, "use local backup files if present")
+	cmdRestore.Flags().StringArrayVar(&cmdRestorePaths, "path", nil, "only restore files matching this glob pattern (may be repeated)")
 }
@@ -137,6 +137,31 @@ func NewReader(r io.Reader) (*Reader, error) {
 	}, nil
 }
 
+// Read the header of data in the uback format without consuming it: the returned reader yields the
+// whole data, header included
+func PeekHeader(r io.Reader) (io.Reader, *uback.Options, error) {
+	m := make([]byte, len(magic))
+	_, err := io.ReadFull(r, m)
+	if err != nil {
+		return nil, nil, err
+	}
+	if string(m) != magic {
+		return nil, nil, ErrInvalidMagicHeader
+	}
+
+	br := bufio.NewReader(r)
+	optionsLine, err := br.ReadString('\n')
+	if err != nil {
+		return nil, nil, err
+	}
+	opts, err := uback.EvalOptions(uback.SplitOptions(strings.TrimSpace(optionsLine)), make(map[string][]uback.KeyValuePair))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return io.MultiReader(strings.NewReader(magic+optionsLine), br), opts, nil
+}
+
 // Prepares the decryption process. This must be called before any Read() call
 func (r *Reader) Unseal(identities []age.Identity) error {
 	var err error
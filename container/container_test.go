@@ -229,3 +229,36 @@ func TestUnencrypted(t *testing.T) {
 		t.Errorf("different plaintext; expected: %v, got: %v", m, m2)
 	}
 }
+
+func TestPeekHeader(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	w, err := NewWriter(buf, nil, "test", 3)
+	if err != nil {
+		t.Fatalf("cannot create writer: %v", err)
+	}
+	if _, err = w.Write([]byte("hello")); err != nil {
+		t.Fatalf("cannot write plaintext: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("cannot close writer: %v", err)
+	}
+	sealed := buf.Bytes()
+
+	r, opts, err := PeekHeader(bytes.NewReader(sealed))
+	if err != nil {
+		t.Fatalf("cannot peek header: %v", err)
+	}
+	if opts.String["Type"] != "test" || opts.String["Plain"] != "1" {
+		t.Errorf("unexpected header options: %v", opts.String)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(data, sealed) {
+		t.Errorf("peeking the header must not consume data: %v", err)
+	}
+
+	_, _, err = PeekHeader(strings.NewReader("raw backup data, not sealed\n"))
+	if !errors.Is(err, ErrInvalidMagicHeader) {
+		t.Errorf("expected %v, got %v", ErrInvalidMagicHeader, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/sloonz/uback/container"
 	"github.com/sloonz/uback/lib"
 
+	"context"
 	"errors"
 	"io"
 	"os"
@@ -82,6 +83,12 @@ func (d *btrfsDestination) RemoveBackup(backup uback.Backup) error {
 }
 
 func (d *btrfsDestination) SendBackup(backup uback.Backup, data io.Reader) error {
+	return d.SendBackupContext(context.Background(), backup, data)
+}
+
+// Part of uback.ContextDestination interface. btrfs receive is killed when ctx is done, and the
+// partially received subvolume is deleted.
+func (d *btrfsDestination) SendBackupContext(ctx context.Context, backup uback.Backup, data io.Reader) error {
 	cr, err := container.NewReader(data)
 	if err != nil {
 		return err
@@ -92,10 +99,14 @@ func (d *btrfsDestination) SendBackup(backup uback.Backup, data io.Reader) error
 		return err
 	}
 
+	tmpPath := path.Join(d.basePath, "_tmp-"+backup.Snapshot.Name())
 	cmd := uback.BuildCommand(d.receiveCommand, d.basePath)
 	cmd.Stdin = cr
-	err = uback.RunCommand(btrfsLog, cmd)
+	err = uback.RunCommandContext(ctx, btrfsLog, cmd)
 	if err != nil {
+		if _, statErr := os.Stat(tmpPath); statErr == nil {
+			_ = uback.RunCommand(btrfsLog, uback.BuildCommand(d.deleteCommand, tmpPath))
+		}
 		return err
 	}
 
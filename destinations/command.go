@@ -4,14 +4,12 @@ import (
 	"github.com/sloonz/uback/lib"
 
 	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
 	"io"
 	"os"
 	"strings"
 
-	"github.com/gobuffalo/flect"
 	"github.com/sirupsen/logrus"
 )
 
@@ -34,23 +32,17 @@ func newCommandDestination(options *uback.Options) (uback.Destination, error) {
 		return nil, ErrCommandMissing
 	}
 
-	env := os.Environ()
-	for k, v := range options.String {
-		env = append(env, fmt.Sprintf("UBACK_OPT_%s=%s", flect.New(k).Underscore().ToUpper().String(), v))
-	}
-	for k, v := range options.StrSlice {
-		jsonVal, err := json.Marshal(v)
-		if err != nil {
-			return nil, err
-		}
-		env = append(env, fmt.Sprintf("UBACK_SOPT_%s=%s", flect.New(k).Underscore().ToUpper().String(), string(jsonVal)))
+	optionsEnv, err := options.Environment("UBACK_")
+	if err != nil {
+		return nil, err
 	}
+	env := append(os.Environ(), optionsEnv...)
 
 	buf := bytes.NewBuffer(nil)
 	cmd := uback.BuildCommand(command, "destination", "validate-options")
 	cmd.Stdout = buf
 	cmd.Env = env
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		return nil, err
 	}
@@ -109,10 +101,16 @@ func (d *commandDestination) RemoveBackup(backup uback.Backup) error {
 }
 
 func (d *commandDestination) SendBackup(backup uback.Backup, data io.Reader) error {
+	return d.SendBackupContext(context.Background(), backup, data)
+}
+
+// Part of uback.ContextDestination interface. The command is killed when ctx is done, so that it
+// never sees the end of a truncated backup.
+func (d *commandDestination) SendBackupContext(ctx context.Context, backup uback.Backup, data io.Reader) error {
 	cmd := uback.BuildCommand(d.command, "destination", "send-backup", backup.FullName())
 	cmd.Stdin = data
 	cmd.Env = d.env
-	return uback.RunCommand(commandLog, cmd)
+	return uback.RunCommandContext(ctx, commandLog, cmd)
 }
 
 func (d *commandDestination) ReceiveBackup(backup uback.Backup) (io.ReadCloser, error) {
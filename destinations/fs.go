@@ -46,7 +46,7 @@ func (d *fsDestination) ListBackups() ([]uback.Backup, error) {
 	}
 
 	for _, entry := range entries {
-		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") || entry.IsDir() {
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") || entry.IsDir() || uback.IsMetadataFilename(entry.Name()) {
 			continue
 		}
 
@@ -70,8 +70,120 @@ func (d *fsDestination) RemoveBackup(backup uback.Backup) error {
 }
 
 func (d *fsDestination) SendBackup(backup uback.Backup, data io.Reader) error {
-	tmpFilename := path.Join(d.basePath, "_tmp-"+backup.Filename())
-	finalFilename := path.Join(d.basePath, backup.Filename())
+	return d.send("backup", backup.Filename(), data)
+}
+
+func (d *fsDestination) ReceiveBackup(backup uback.Backup) (io.ReadCloser, error) {
+	return os.Open(path.Join(d.basePath, backup.Filename()))
+}
+
+func (d *fsDestination) ListCatalogs() ([]uback.Backup, error) {
+	var res []uback.Backup
+	entries, err := os.ReadDir(d.basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") || entry.IsDir() || !uback.IsCatalogFilename(entry.Name()) {
+			continue
+		}
+
+		backup, err := uback.ParseCatalogFilename(entry.Name())
+		if err != nil {
+			fsLog.WithFields(logrus.Fields{
+				"file": entry.Name(),
+			}).Warnf("invalid catalog file: %v", err)
+			continue
+		}
+
+		res = append(res, backup)
+	}
+
+	return res, nil
+}
+
+func (d *fsDestination) RemoveCatalog(backup uback.Backup) error {
+	err := os.Remove(path.Join(d.basePath, backup.CatalogFilename()))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *fsDestination) SendCatalog(backup uback.Backup, data io.Reader) error {
+	return d.send("catalog", backup.CatalogFilename(), data)
+}
+
+func (d *fsDestination) ReceiveCatalog(backup uback.Backup) (io.ReadCloser, error) {
+	return os.Open(path.Join(d.basePath, backup.CatalogFilename()))
+}
+
+func (d *fsDestination) ListHolds() ([]uback.Backup, error) {
+	var res []uback.Backup
+	entries, err := os.ReadDir(d.basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") || entry.IsDir() || !uback.IsHoldFilename(entry.Name()) {
+			continue
+		}
+
+		backup, err := uback.ParseHoldFilename(entry.Name())
+		if err != nil {
+			fsLog.WithFields(logrus.Fields{
+				"file": entry.Name(),
+			}).Warnf("invalid hold file: %v", err)
+			continue
+		}
+
+		res = append(res, backup)
+	}
+
+	return res, nil
+}
+
+func (d *fsDestination) ListBackupSizes() (map[string]int64, error) {
+	res := make(map[string]int64)
+	entries, err := os.ReadDir(d.basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") || entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		uback.AddBackupFileSize(res, entry.Name(), info.Size())
+	}
+
+	return res, nil
+}
+
+func (d *fsDestination) HoldBackup(backup uback.Backup) error {
+	return d.send("hold", backup.HoldFilename(), strings.NewReader(""))
+}
+
+func (d *fsDestination) ReleaseBackup(backup uback.Backup) error {
+	err := os.Remove(path.Join(d.basePath, backup.HoldFilename()))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Atomically write a file of the given kind (backup, catalog or hold)
+func (d *fsDestination) send(kind, filename string, data io.Reader) error {
+	tmpFilename := path.Join(d.basePath, "_tmp-"+filename)
+	finalFilename := path.Join(d.basePath, filename)
 	tmpF, err := os.Create(tmpFilename)
 	if err != nil {
 		return err
@@ -79,7 +191,7 @@ func (d *fsDestination) SendBackup(backup uback.Backup, data io.Reader) error {
 	defer tmpF.Close()
 	defer os.Remove(tmpFilename)
 
-	fsLog.Printf("writing backup to %s", tmpFilename)
+	fsLog.Printf("writing %s to %s", kind, tmpFilename)
 	_, err = io.Copy(tmpF, data)
 	if err != nil {
 		return err
@@ -87,10 +199,6 @@ func (d *fsDestination) SendBackup(backup uback.Backup, data io.Reader) error {
 
 	tmpF.Close()
 
-	fsLog.Printf("moving final backup to %s", finalFilename)
+	fsLog.Printf("moving final %s to %s", kind, finalFilename)
 	return os.Rename(tmpFilename, finalFilename)
 }
-
-func (d *fsDestination) ReceiveBackup(backup uback.Backup) (io.ReadCloser, error) {
-	return os.Open(path.Join(d.basePath, backup.Filename()))
-}
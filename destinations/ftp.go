@@ -81,7 +81,7 @@ func (d *ftpDestination) ListBackups() ([]uback.Backup, error) {
 	}
 
 	for _, file := range files {
-		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || strings.HasPrefix(file.Name(), "_") {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || strings.HasPrefix(file.Name(), "_") || uback.IsMetadataFilename(file.Name()) {
 			continue
 		}
 
@@ -114,6 +114,7 @@ func (d *ftpDestination) SendBackup(backup uback.Backup, data io.Reader) error {
 
 	_ = d.makePrefix()
 	if err := d.client.Store(tmpFilePath, data); err != nil {
+		_ = d.client.Delete(tmpFilePath)
 		return fmt.Errorf("failed to write temporary backup file to FTP server: %v", err)
 	}
 
@@ -139,3 +140,147 @@ func (d *ftpDestination) ReceiveBackup(backup uback.Backup) (io.ReadCloser, erro
 
 	return reader, nil
 }
+
+func (d *ftpDestination) ListCatalogs() ([]uback.Backup, error) {
+	var res []uback.Backup
+
+	_ = d.makePrefix()
+	files, err := d.client.ReadDir(d.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list catalogs on FTP server: %v", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || strings.HasPrefix(file.Name(), "_") || !uback.IsCatalogFilename(file.Name()) {
+			continue
+		}
+
+		backup, err := uback.ParseCatalogFilename(file.Name())
+		if err != nil {
+			ftpLog.WithFields(logrus.Fields{
+				"key": file.Name(),
+			}).Warnf("invalid catalog file: %v", err)
+			continue
+		}
+
+		res = append(res, backup)
+	}
+
+	return res, nil
+}
+
+func (d *ftpDestination) RemoveCatalog(backup uback.Backup) error {
+	filePath := path.Join(d.prefix, backup.CatalogFilename())
+	if err := d.client.Delete(filePath); err != nil {
+		// The backup may not have a catalog
+		if _, statErr := d.client.Stat(filePath); statErr != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to remove catalog from FTP server: %v", err)
+	}
+	return nil
+}
+
+func (d *ftpDestination) SendCatalog(backup uback.Backup, data io.Reader) error {
+	tmpFilePath := path.Join(d.prefix, "_tmp"+backup.CatalogFilename())
+	finalFilePath := path.Join(d.prefix, backup.CatalogFilename())
+	ftpLog.Printf("writing catalog to temporary file %s", tmpFilePath)
+
+	_ = d.makePrefix()
+	if err := d.client.Store(tmpFilePath, data); err != nil {
+		return fmt.Errorf("failed to write temporary catalog file to FTP server: %v", err)
+	}
+
+	ftpLog.Printf("renaming temporary file %s to %s", tmpFilePath, finalFilePath)
+	if err := d.client.Rename(tmpFilePath, finalFilePath); err != nil {
+		_ = d.client.Delete(tmpFilePath)
+		return fmt.Errorf("failed to rename temporary catalog file on FTP server: %v", err)
+	}
+
+	return nil
+}
+
+func (d *ftpDestination) ReceiveCatalog(backup uback.Backup) (io.ReadCloser, error) {
+	filePath := path.Join(d.prefix, backup.CatalogFilename())
+
+	reader, writer := io.Pipe()
+	go func() {
+		defer writer.Close()
+		if err := d.client.Retrieve(filePath, writer); err != nil {
+			writer.CloseWithError(fmt.Errorf("failed to read catalog from FTP server: %v", err))
+		}
+	}()
+
+	return reader, nil
+}
+
+func (d *ftpDestination) ListHolds() ([]uback.Backup, error) {
+	var res []uback.Backup
+
+	_ = d.makePrefix()
+	files, err := d.client.ReadDir(d.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list holds on FTP server: %v", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || strings.HasPrefix(file.Name(), "_") || !uback.IsHoldFilename(file.Name()) {
+			continue
+		}
+
+		backup, err := uback.ParseHoldFilename(file.Name())
+		if err != nil {
+			ftpLog.WithFields(logrus.Fields{
+				"key": file.Name(),
+			}).Warnf("invalid hold file: %v", err)
+			continue
+		}
+
+		res = append(res, backup)
+	}
+
+	return res, nil
+}
+
+func (d *ftpDestination) ListBackupSizes() (map[string]int64, error) {
+	res := make(map[string]int64)
+
+	_ = d.makePrefix()
+	files, err := d.client.ReadDir(d.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups on FTP server: %v", err)
+	}
+
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") || strings.HasPrefix(file.Name(), "_") {
+			continue
+		}
+
+		uback.AddBackupFileSize(res, file.Name(), file.Size())
+	}
+
+	return res, nil
+}
+
+func (d *ftpDestination) HoldBackup(backup uback.Backup) error {
+	filePath := path.Join(d.prefix, backup.HoldFilename())
+	ftpLog.Printf("writing hold marker to %s", filePath)
+
+	_ = d.makePrefix()
+	if err := d.client.Store(filePath, strings.NewReader("")); err != nil {
+		return fmt.Errorf("failed to write hold marker to FTP server: %v", err)
+	}
+	return nil
+}
+
+func (d *ftpDestination) ReleaseBackup(backup uback.Backup) error {
+	filePath := path.Join(d.prefix, backup.HoldFilename())
+	if err := d.client.Delete(filePath); err != nil {
+		// The backup may not be held
+		if _, statErr := d.client.Stat(filePath); statErr != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to remove hold marker from FTP server: %v", err)
+	}
+	return nil
+}
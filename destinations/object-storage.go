@@ -111,7 +111,7 @@ func (d *objectStorageDestination) ListBackups() ([]uback.Backup, error) {
 			return nil, fmt.Errorf("failed to list backups on object storage: %v", obj.Err)
 		}
 
-		if strings.HasPrefix(obj.Key, ".") || strings.HasPrefix(obj.Key, "_") || strings.HasSuffix(obj.Key, "/") {
+		if strings.HasPrefix(obj.Key, ".") || strings.HasPrefix(obj.Key, "_") || strings.HasSuffix(obj.Key, "/") || uback.IsMetadataFilename(obj.Key) {
 			continue
 		}
 
@@ -139,8 +139,13 @@ func (d *objectStorageDestination) RemoveBackup(backup uback.Backup) error {
 }
 
 func (d *objectStorageDestination) SendBackup(backup uback.Backup, data io.Reader) error {
+	return d.SendBackupContext(context.Background(), backup, data)
+}
+
+// Part of uback.ContextDestination interface
+func (d *objectStorageDestination) SendBackupContext(ctx context.Context, backup uback.Backup, data io.Reader) error {
 	osLog.Printf("writing backup to %s", d.prefix+backup.Filename())
-	_, err := d.client.PutObject(context.Background(), d.bucket, d.prefix+backup.Filename(), data, -1, minio.PutObjectOptions{PartSize: d.partSize})
+	_, err := d.client.PutObject(ctx, d.bucket, d.prefix+backup.Filename(), data, -1, minio.PutObjectOptions{PartSize: d.partSize})
 	if err != nil {
 		d.client.RemoveObject(context.Background(), d.bucket, d.prefix+backup.Filename(), minio.RemoveObjectOptions{}) //nolint:errcheck
 		return fmt.Errorf("failed to write backup to object storage: %v", err)
@@ -155,3 +160,137 @@ func (d *objectStorageDestination) ReceiveBackup(backup uback.Backup) (io.ReadCl
 	}
 	return rc, nil
 }
+
+func (d *objectStorageDestination) ListCatalogs() ([]uback.Backup, error) {
+	var res []uback.Backup
+
+	ctx, cancel := context.WithCancel(context.Background())
+	objectsCh := d.client.ListObjects(ctx, d.bucket, minio.ListObjectsOptions{
+		Prefix:    d.prefix,
+		Recursive: false,
+	})
+	defer cancel()
+
+	for obj := range objectsCh {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list catalogs on object storage: %v", obj.Err)
+		}
+
+		if strings.HasPrefix(obj.Key, ".") || strings.HasPrefix(obj.Key, "_") || strings.HasSuffix(obj.Key, "/") || !uback.IsCatalogFilename(obj.Key) {
+			continue
+		}
+
+		backup, err := uback.ParseCatalogFilename(path.Base(obj.Key))
+		if err != nil {
+			osLog.WithFields(logrus.Fields{
+				"key": obj.Key,
+			}).Warnf("invalid catalog file: %v", err)
+			continue
+		}
+
+		res = append(res, backup)
+	}
+
+	return res, nil
+}
+
+func (d *objectStorageDestination) RemoveCatalog(backup uback.Backup) error {
+	err := d.client.RemoveObject(context.Background(), d.bucket, d.prefix+backup.CatalogFilename(), minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove catalog from object storage: %v", err)
+	}
+	return nil
+}
+
+func (d *objectStorageDestination) SendCatalog(backup uback.Backup, data io.Reader) error {
+	osLog.Printf("writing catalog to %s", d.prefix+backup.CatalogFilename())
+	_, err := d.client.PutObject(context.Background(), d.bucket, d.prefix+backup.CatalogFilename(), data, -1, minio.PutObjectOptions{PartSize: d.partSize})
+	if err != nil {
+		d.client.RemoveObject(context.Background(), d.bucket, d.prefix+backup.CatalogFilename(), minio.RemoveObjectOptions{}) //nolint:errcheck
+		return fmt.Errorf("failed to write catalog to object storage: %v", err)
+	}
+	return nil
+}
+
+func (d *objectStorageDestination) ReceiveCatalog(backup uback.Backup) (io.ReadCloser, error) {
+	rc, err := d.client.GetObject(context.Background(), d.bucket, d.prefix+backup.CatalogFilename(), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read catalog from object storage: %v", err)
+	}
+	return rc, nil
+}
+
+func (d *objectStorageDestination) ListHolds() ([]uback.Backup, error) {
+	var res []uback.Backup
+
+	ctx, cancel := context.WithCancel(context.Background())
+	objectsCh := d.client.ListObjects(ctx, d.bucket, minio.ListObjectsOptions{
+		Prefix:    d.prefix,
+		Recursive: false,
+	})
+	defer cancel()
+
+	for obj := range objectsCh {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list holds on object storage: %v", obj.Err)
+		}
+
+		if strings.HasPrefix(obj.Key, ".") || strings.HasPrefix(obj.Key, "_") || strings.HasSuffix(obj.Key, "/") || !uback.IsHoldFilename(obj.Key) {
+			continue
+		}
+
+		backup, err := uback.ParseHoldFilename(path.Base(obj.Key))
+		if err != nil {
+			osLog.WithFields(logrus.Fields{
+				"key": obj.Key,
+			}).Warnf("invalid hold file: %v", err)
+			continue
+		}
+
+		res = append(res, backup)
+	}
+
+	return res, nil
+}
+
+func (d *objectStorageDestination) ListBackupSizes() (map[string]int64, error) {
+	res := make(map[string]int64)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	objectsCh := d.client.ListObjects(ctx, d.bucket, minio.ListObjectsOptions{
+		Prefix:    d.prefix,
+		Recursive: false,
+	})
+	defer cancel()
+
+	for obj := range objectsCh {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list backups on object storage: %v", obj.Err)
+		}
+
+		if strings.HasPrefix(obj.Key, ".") || strings.HasPrefix(obj.Key, "_") || strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+
+		uback.AddBackupFileSize(res, path.Base(obj.Key), obj.Size)
+	}
+
+	return res, nil
+}
+
+func (d *objectStorageDestination) HoldBackup(backup uback.Backup) error {
+	osLog.Printf("writing hold marker to %s", d.prefix+backup.HoldFilename())
+	_, err := d.client.PutObject(context.Background(), d.bucket, d.prefix+backup.HoldFilename(), strings.NewReader(""), 0, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to write hold marker to object storage: %v", err)
+	}
+	return nil
+}
+
+func (d *objectStorageDestination) ReleaseBackup(backup uback.Backup) error {
+	err := d.client.RemoveObject(context.Background(), d.bucket, d.prefix+backup.HoldFilename(), minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to remove hold marker from object storage: %v", err)
+	}
+	return nil
+}
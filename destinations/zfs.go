@@ -2,6 +2,7 @@ package destinations
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"strings"
 
@@ -106,6 +107,12 @@ func (d *zfsDestination) RemoveBackup(backup uback.Backup) error {
 }
 
 func (d *zfsDestination) SendBackup(backup uback.Backup, data io.Reader) error {
+	return d.SendBackupContext(context.Background(), backup, data)
+}
+
+// Part of uback.ContextDestination interface. zfs receive is killed when ctx is done, which aborts
+// the reception.
+func (d *zfsDestination) SendBackupContext(ctx context.Context, backup uback.Backup, data io.Reader) error {
 	cr, err := container.NewReader(data)
 	if err != nil {
 		return err
@@ -118,7 +125,7 @@ func (d *zfsDestination) SendBackup(backup uback.Backup, data io.Reader) error {
 
 	cmd := uback.BuildCommand(d.receiveCommand, "-o", "readonly=on", d.dataset)
 	cmd.Stdin = cr
-	return uback.RunCommand(zfsLog, cmd)
+	return uback.RunCommandContext(ctx, zfsLog, cmd)
 }
 
 func (d *zfsDestination) ReceiveBackup(backup uback.Backup) (io.ReadCloser, error) {
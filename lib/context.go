@@ -0,0 +1,200 @@
+package uback
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Return the cause of the cancellation of ctx, if any
+func contextError(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
+
+type contextReader struct {
+	ctx      context.Context
+	r        io.Reader
+	onCancel func()
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := contextError(r.ctx); err != nil {
+		return 0, err
+	}
+
+	n, err := r.r.Read(p)
+	if err != nil {
+		if ctxErr := contextError(r.ctx); ctxErr != nil {
+			// Whatever the reason of err, the data may be truncated
+			if r.onCancel != nil {
+				r.onCancel()
+			}
+			return 0, ctxErr
+		}
+	}
+	return n, err
+}
+
+// Make a reader failing with the cause of the cancellation of ctx once ctx is done. If not nil,
+// onCancel is called before the error is returned.
+func NewContextReader(ctx context.Context, r io.Reader, onCancel func()) io.Reader {
+	return &contextReader{ctx: ctx, r: r, onCancel: onCancel}
+}
+
+type contextReadCloser struct {
+	io.Reader
+	rc        io.ReadCloser
+	stop      func() bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *contextReadCloser) Close() error {
+	c.stop()
+	c.closeOnce.Do(func() {
+		c.closeErr = c.rc.Close()
+	})
+	return c.closeErr
+}
+
+// Make a ReadCloser failing with the cause of the cancellation of ctx once ctx is done. rc is
+// closed as soon as ctx is done, unblocking any pending read.
+func NewContextReadCloser(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	c := &contextReadCloser{Reader: NewContextReader(ctx, rc, nil), rc: rc}
+	c.stop = context.AfterFunc(ctx, func() {
+		c.closeOnce.Do(func() {
+			c.closeErr = c.rc.Close()
+		})
+	})
+	return c
+}
+
+// Create a backup from src, aborting it when ctx is done. The data of the backup is closed as soon
+// as ctx is done, so sources must stop producing it and remove their temporary snapshots when it
+// is closed (see WrapCleanup).
+func CreateBackupContext(ctx context.Context, src Source, baseSnapshot *Snapshot) (Backup, io.ReadCloser, error) {
+	if err := contextError(ctx); err != nil {
+		return Backup{}, nil, err
+	}
+
+	backup, data, err := src.CreateBackup(baseSnapshot)
+	if err != nil {
+		return Backup{}, nil, err
+	}
+
+	return backup, NewContextReadCloser(ctx, data), nil
+}
+
+// Send a backup to dst, aborting it when ctx is done
+func SendBackupContext(ctx context.Context, dst Destination, backup Backup, data io.Reader) error {
+	if cd, ok := dst.(ContextDestination); ok {
+		return cd.SendBackupContext(ctx, backup, data)
+	}
+
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	err := dst.SendBackup(backup, NewContextReader(ctx, data, nil))
+	if ctxErr := contextError(ctx); err != nil && ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// Kill a started command, if it is still running
+func KillCommand(cmd *exec.Cmd) error {
+	err := cmd.Process.Kill()
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return err
+}
+
+// Like RunCommand, but kill the command when ctx is done. If the command reads its standard input
+// from a reader, it is killed before it can see the end of a truncated input, and a pending read
+// of the reader is not waited for.
+func RunCommandContext(ctx context.Context, log *logrus.Entry, cmd *exec.Cmd) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	var stdin io.Reader
+	var stdinPipe io.WriteCloser
+	if _, ok := cmd.Stdin.(*os.File); !ok && cmd.Stdin != nil {
+		// Copy the standard input ourselves, since exec.Cmd.Wait() would wait for a pending read
+		stdin = NewContextReader(ctx, cmd.Stdin, func() { _ = KillCommand(cmd) })
+		cmd.Stdin = nil
+
+		var err error
+		stdinPipe, err = cmd.StdinPipe()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := StartCommand(log, cmd)
+	if err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() { _ = KillCommand(cmd) })
+	defer stop()
+
+	copyErr := make(chan error, 1)
+	if stdin != nil {
+		go func() {
+			_, err := io.Copy(stdinPipe, stdin)
+			stdinPipe.Close()
+			copyErr <- err
+		}()
+	} else {
+		copyErr <- nil
+	}
+
+	err = cmd.Wait()
+	if ctxErr := contextError(ctx); err != nil && ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Like exec.Cmd.Wait(), ignore the command not reading its whole input
+	err = <-copyErr
+	if errors.Is(err, syscall.EPIPE) || errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+type commandReadCloser struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	done <-chan struct{}
+}
+
+func (c *commandReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	if killErr := KillCommand(c.cmd); err == nil {
+		err = killErr
+	}
+	<-c.done
+	return err
+}
+
+// Make closing rc, the reader of the standard output of a started command, kill the command if it
+// is still running, then wait for done to be closed (once the result of cmd.Wait() has been handled),
+// so that any cleanup is complete when Close returns
+func WrapCommandCloser(rc io.ReadCloser, cmd *exec.Cmd, done <-chan struct{}) io.ReadCloser {
+	return &commandReadCloser{ReadCloser: rc, cmd: cmd, done: done}
+}
@@ -0,0 +1,56 @@
+package uback
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestContextReadCloser(t *testing.T) {
+	cause := errors.New("cancelled")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	rc := NewContextReadCloser(ctx, pr)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel(cause)
+	}()
+
+	// The pending read must be unblocked
+	_, err := rc.Read(make([]byte, 1))
+	if err != cause {
+		t.Errorf("expected %v, got %v", cause, err)
+	}
+
+	if err = rc.Close(); err != nil {
+		t.Errorf("unexpected close error: %v", err)
+	}
+}
+
+func TestRunCommandContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// cat would store the truncated input if it was not killed before seeing its end
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	cmd := exec.Command("cat")
+	cmd.Stdin = pr
+
+	err := RunCommandContext(ctx, logrus.WithFields(logrus.Fields{}), cmd)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	err = RunCommandContext(context.Background(), logrus.WithFields(logrus.Fields{}), exec.Command("true"))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -1,7 +1,10 @@
 package uback
 
 import (
+	"context"
 	"io"
+	"io/fs"
+	"time"
 )
 
 // Represents a snapshot. Should be in the YYYYMMDDTHHMMSS.MMM format.
@@ -54,3 +57,87 @@ type Destination interface {
 	// Retrieve the content of a previously stored backup
 	ReceiveBackup(backup Backup) (io.ReadCloser, error)
 }
+
+// Optional interface for sources whose backup data is already in the uback container format
+// (compressed and, unless NoEncryption is set, encrypted), for example because this has been
+// delegated to a remote process
+type SealedSource interface {
+	// If true, the reader returned by CreateBackup must be stored as-is
+	IsSealed() bool
+}
+
+// A file inside a backup
+type BackupEntry struct {
+	// Path relative to the root of the backup, without leading "./" nor trailing "/"
+	Path     string      `json:"path"`
+	Mode     fs.FileMode `json:"mode"`
+	Size     int64       `json:"size"`
+	ModTime  time.Time   `json:"mtime"`
+	Linkname string      `json:"linkname,omitempty"`
+
+	// Hex-encoded SHA-256 of the content of regular files
+	Hash string `json:"hash,omitempty"`
+}
+
+// Optional interface for sources whose backups can be browsed and partially restored. Like
+// RestoreBackup, it only has to be implemented by sources created for restoration.
+type BrowsableSource interface {
+	// Like RestoreBackup, but only restore entries whose path (or the path of one of their
+	// parents) match one of the patterns (see MatchBackupPath)
+	RestorePartialBackup(target string, backup Backup, data io.Reader, patterns []string) error
+
+	// Apply the content of a backup onto `entries`, indexed by path. If `backup` is an incremental
+	// backup, `entries` already contains the content of its base.
+	ListBackupEntries(entries map[string]BackupEntry, backup Backup, data io.Reader) error
+}
+
+// Optional interface for destinations able to store a catalog of the content of a backup next to
+// it (see BackupEntry)
+type CatalogDestination interface {
+	// List backups having a catalog
+	ListCatalogs() ([]Backup, error)
+
+	// Remove the catalog of a backup. Must not fail if the backup has no catalog.
+	RemoveCatalog(backup Backup) error
+
+	// Store the catalog of a backup whose data is `data`
+	SendCatalog(backup Backup, data io.Reader) error
+
+	// Retrieve the content of a previously stored catalog
+	ReceiveCatalog(backup Backup) (io.ReadCloser, error)
+}
+
+// Optional interface for destinations able to hold backups, preventing them from being pruned
+// by retention policies
+type HoldDestination interface {
+	// List held backups
+	ListHolds() ([]Backup, error)
+
+	// Hold a backup
+	HoldBackup(backup Backup) error
+
+	// Release a held backup. Must not fail if the backup is not held.
+	ReleaseBackup(backup Backup) error
+}
+
+// Optional interface for destinations able to report the space used by backups, required by the
+// MaxSize option
+type SizedDestination interface {
+	// Return the size in bytes of each backup (including its catalog), indexed by Backup.FullName()
+	ListBackupSizes() (map[string]int64, error)
+}
+
+// Optional interface for sources able to estimate the size of a backup before creating it
+type SizeEstimator interface {
+	// Estimate the size in bytes of the data of a backup from baseSnapshot (nil for a full backup),
+	// before compression and encryption. The source must not create any snapshot.
+	EstimateBackupSize(baseSnapshot *Snapshot) (int64, error)
+}
+
+// Optional interface for destinations able to cancel the storage of a backup. Destinations not
+// implementing it are adapted by SendBackupContext.
+type ContextDestination interface {
+	// Like SendBackup, but the storage must be aborted when ctx is done: the backup must not be
+	// stored, and temporary files must be removed before returning.
+	SendBackupContext(ctx context.Context, backup Backup, data io.Reader) error
+}
@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/gobuffalo/flect"
@@ -85,6 +86,30 @@ func (o *Options) GetString(key string, defaults string) string {
 	}
 }
 
+func (o *Options) GetDuration(key string, defaults time.Duration) (time.Duration, error) {
+	if s, ok := o.String[key]; ok {
+		return time.ParseDuration(s)
+	}
+	return defaults, nil
+}
+
+// Export options as environment variables: `<prefix>OPT_<KEY>` for normal options,
+// `<prefix>SOPT_<KEY>` (as a JSON array) for slice options
+func (o *Options) Environment(prefix string) ([]string, error) {
+	var env []string
+	for k, v := range o.String {
+		env = append(env, fmt.Sprintf("%sOPT_%s=%s", prefix, flect.New(k).Underscore().ToUpper().String(), v))
+	}
+	for k, v := range o.StrSlice {
+		jsonVal, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf("%sSOPT_%s=%s", prefix, flect.New(k).Underscore().ToUpper().String(), string(jsonVal)))
+	}
+	return env, nil
+}
+
 // Parse retention policies
 func (o *Options) GetRetentionPolicies() ([]RetentionPolicy, error) {
 	var policies []RetentionPolicy
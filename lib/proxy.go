@@ -79,7 +79,7 @@ func ProxiedOptions(options *Options) Options {
 	}
 
 	for k, v := range options.String {
-		if k != "Proxy" && k != "Command" && k != "Type" {
+		if k != "Proxy" && k != "Command" && k != "Type" && k != "RemoteEncryption" {
 			opts.String[strings.TrimPrefix(k, "Proxy")] = v
 		}
 	}
@@ -2,6 +2,7 @@ package uback
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -21,8 +22,23 @@ type RetentionPolicy struct {
 	Interval int  // Minimum interval between two backups
 	Count    int  // Maximum number of retained backups
 	FullOnly bool // If true, only retain full backups
+
+	// If not zero, retain one backup per calendar period (one of the ymwdh units of intervals)
+	// instead of using Interval
+	CalendarUnit byte
+	// If true, retain the oldest backup of each calendar period instead of the most recent one
+	CalendarFirst bool
+	// Timezone used to compute calendar periods
+	Location *time.Location
+
+	// If not zero, retain all backups more recent than this number of seconds, instead of using
+	// Interval and Count
+	Within int
 }
 
+// Current time, used by "within" policies (replaceable in tests)
+var timeNow = time.Now
+
 // Can be a backup or a snapshot
 type RetentionPolicySubject interface {
 	Time() (time.Time, error)
@@ -75,6 +91,38 @@ func ParseInterval(intv string) (int, error) {
 	return result, nil
 }
 
+// Parse a rule (within=interval, last=count or min-full=count), with its options
+func parseRetentionRule(k string, v []string) (RetentionPolicy, error) {
+	var parsedPolicy RetentionPolicy
+	var err error
+	switch k {
+	case "within":
+		parsedPolicy.Within, err = ParseInterval(strings.TrimSpace(v[0]))
+		if err == nil && parsedPolicy.Within <= 0 {
+			err = fmt.Errorf("invalid interval")
+		}
+	case "last", "min-full":
+		parsedPolicy.Count, err = strconv.Atoi(strings.TrimSpace(v[0]))
+		if err == nil && parsedPolicy.Count <= 0 {
+			err = fmt.Errorf("invalid count")
+		}
+		parsedPolicy.FullOnly = k == "min-full"
+	}
+	if err != nil {
+		return RetentionPolicy{}, err
+	}
+
+	for _, opt := range v[1:] {
+		if strings.TrimSpace(opt) == "full" && k != "min-full" {
+			parsedPolicy.FullOnly = true
+		} else {
+			return RetentionPolicy{}, fmt.Errorf("invalid option")
+		}
+	}
+
+	return parsedPolicy, nil
+}
+
 func ParseRetentionPolicy(policy string) (RetentionPolicy, error) {
 	kv := strings.SplitN(policy, "=", 2)
 	if len(kv) != 2 {
@@ -83,6 +131,10 @@ func ParseRetentionPolicy(policy string) (RetentionPolicy, error) {
 
 	k := strings.TrimSpace(kv[0])
 	v := strings.Split(kv[1], ":")
+	if k == "within" || k == "last" || k == "min-full" {
+		return parseRetentionRule(k, v)
+	}
+
 	count, err := strconv.Atoi(strings.TrimSpace(v[0]))
 	if err != nil {
 		return RetentionPolicy{}, err
@@ -99,23 +151,201 @@ func ParseRetentionPolicy(policy string) (RetentionPolicy, error) {
 		FullOnly: false,
 	}
 
+	calendar := false
 	if len(v) > 1 {
 		for _, opt := range v[1:] {
-			if strings.TrimSpace(opt) == "full" {
+			opt = strings.TrimSpace(opt)
+			switch {
+			case opt == "full":
 				parsedPolicy.FullOnly = true
-			} else {
+			case opt == "calendar":
+				calendar = true
+			case opt == "first":
+				parsedPolicy.CalendarFirst = true
+			case strings.HasPrefix(opt, "tz="):
+				parsedPolicy.Location, err = time.LoadLocation(opt[3:])
+				if err != nil {
+					return RetentionPolicy{}, err
+				}
+			default:
 				return RetentionPolicy{}, fmt.Errorf("invalid option")
 			}
 		}
 	}
 
+	if calendar {
+		parsedPolicy.CalendarUnit, err = parseCalendarUnit(k)
+		if err != nil {
+			return RetentionPolicy{}, err
+		}
+		if parsedPolicy.Location == nil {
+			parsedPolicy.Location = time.Local
+		}
+	} else if parsedPolicy.CalendarFirst || parsedPolicy.Location != nil {
+		return RetentionPolicy{}, fmt.Errorf("first and tz options require the calendar option")
+	}
+
 	return parsedPolicy, nil
 }
 
+// Parse the unit of a calendar retention policy, which must be a single hour, day, week, month or year
+func parseCalendarUnit(intv string) (byte, error) {
+	alias, ok := intervalAliases[intv]
+	if ok {
+		intv = alias
+	}
+
+	if len(intv) < 2 || intv[:len(intv)-1] != "1" || !strings.Contains("ymwdh", string(intv[len(intv)-1])) {
+		return 0, fmt.Errorf("calendar retention policies require a single hour, day, week, month or year interval")
+	}
+
+	return intv[len(intv)-1], nil
+}
+
+// Format an interval (in seconds) using the largest unit dividing it
+func formatInterval(intv int) string {
+	for _, u := range []struct {
+		suffix  string
+		seconds int
+	}{{"y", 365 * 24 * 3600}, {"m", 30 * 24 * 3600}, {"w", 7 * 24 * 3600}, {"d", 24 * 3600}, {"h", 3600}} {
+		if intv != 0 && intv%u.seconds == 0 {
+			return strconv.Itoa(intv/u.seconds) + u.suffix
+		}
+	}
+	return strconv.Itoa(intv)
+}
+
+// Format the retention policy in the syntax accepted by ParseRetentionPolicy
+func (p RetentionPolicy) String() string {
+	var s string
+	switch {
+	case p.Within != 0:
+		s = "within=" + formatInterval(p.Within)
+	case p.Interval == 0 && p.CalendarUnit == 0 && p.FullOnly:
+		return "min-full=" + strconv.Itoa(p.Count)
+	case p.Interval == 0 && p.CalendarUnit == 0:
+		s = "last=" + strconv.Itoa(p.Count)
+	case p.CalendarUnit != 0:
+		s = "1" + string(p.CalendarUnit) + "=" + strconv.Itoa(p.Count) + ":calendar"
+		if p.CalendarFirst {
+			s += ":first"
+		}
+		if p.Location != nil && p.Location != time.Local {
+			s += ":tz=" + p.Location.String()
+		}
+	default:
+		s = formatInterval(p.Interval) + "=" + strconv.Itoa(p.Count)
+	}
+	if p.FullOnly {
+		s += ":full"
+	}
+	return s
+}
+
+// Return the calendar period (of the given unit) containing t, in loc
+func calendarPeriod(t time.Time, unit byte, loc *time.Location) string {
+	t = t.In(loc)
+	switch unit {
+	case 'y':
+		return t.Format("2006")
+	case 'm':
+		return t.Format("2006-01")
+	case 'w':
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case 'd':
+		return t.Format("2006-01-02")
+	default:
+		return t.Format("2006-01-02T15")
+	}
+}
+
+// Apply a calendar retention policy to a set of subjects (sorted from the most recent to the oldest),
+// adding the retained subject names to retained
+func applyCalendarRetentionPolicy(policy RetentionPolicy, subjects []RetentionPolicySubject, retained map[string][]string) error {
+	var periods []string
+	selected := make(map[string]string)
+	for _, subject := range subjects {
+		if policy.FullOnly && !subject.IsFull() {
+			continue
+		}
+		t, err := subject.Time()
+		if err != nil {
+			return err
+		}
+
+		period := calendarPeriod(t, policy.CalendarUnit, policy.Location)
+		if _, ok := selected[period]; !ok {
+			if len(periods) >= policy.Count {
+				break
+			}
+			periods = append(periods, period)
+			selected[period] = subject.Name()
+		} else if policy.CalendarFirst {
+			selected[period] = subject.Name()
+		}
+	}
+
+	for _, period := range periods {
+		retained[selected[period]] = append(retained[selected[period]], policy.String())
+	}
+
+	return nil
+}
+
+// Apply a "within" retention policy to a set of subjects, adding the retained subject names to retained
+func applyWithinRetentionPolicy(policy RetentionPolicy, subjects []RetentionPolicySubject, retained map[string][]string) error {
+	now := timeNow()
+	for _, subject := range subjects {
+		if policy.FullOnly && !subject.IsFull() {
+			continue
+		}
+		t, err := subject.Time()
+		if err != nil {
+			return err
+		}
+		if now.Sub(t).Seconds() < float64(policy.Within) {
+			retained[subject.Name()] = append(retained[subject.Name()], policy.String())
+		}
+	}
+	return nil
+}
+
 // Apply retention policies to a set of subjects, returning a set of retained subject names
 func ApplyRetentionPolicies(policies []RetentionPolicy, subjects []RetentionPolicySubject) (map[string]struct{}, error) {
+	explanations, err := ExplainRetentionPolicies(policies, subjects)
+	if err != nil {
+		return nil, err
+	}
+
 	retained := make(map[string]struct{})
+	for name := range explanations {
+		retained[name] = struct{}{}
+	}
+	return retained, nil
+}
+
+// Apply retention policies to a set of subjects, returning the retained subject names, each one with
+// the policies retaining it
+func ExplainRetentionPolicies(policies []RetentionPolicy, subjects []RetentionPolicySubject) (map[string][]string, error) {
+	retained := make(map[string][]string)
 	for _, policy := range policies {
+		if policy.Within != 0 {
+			err := applyWithinRetentionPolicy(policy, subjects, retained)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if policy.CalendarUnit != 0 {
+			err := applyCalendarRetentionPolicy(policy, subjects, retained)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
 		var lastRetainedTime time.Time
 		retainedCount := 0
 		for _, subject := range subjects {
@@ -131,7 +361,7 @@ func ApplyRetentionPolicies(policies []RetentionPolicy, subjects []RetentionPoli
 			}
 			if retainedCount == 0 || lastRetainedTime.Sub(t).Seconds() >= 0.9*float64(policy.Interval) {
 				lastRetainedTime = t
-				retained[subject.Name()] = struct{}{}
+				retained[subject.Name()] = append(retained[subject.Name()], policy.String())
 				retainedCount++
 			}
 		}
@@ -139,8 +369,19 @@ func ApplyRetentionPolicies(policies []RetentionPolicy, subjects []RetentionPoli
 	return retained, nil
 }
 
-// Get backups from a destination not retained by a given retention policy
-func GetPrunedBackups(backups []Backup, policies []RetentionPolicy) ([]Backup, error) {
+// Why a backup or a snapshot is retained or pruned
+type RetentionExplanation struct {
+	Name     string
+	Retained bool
+	Reasons  []string
+
+	// If true, the backup is only retained because other retained backups depend on it
+	Dependency bool
+}
+
+// Explain which backups from a destination are retained by a given retention policy, and why.
+// Held backups, and the backups they depend on, are always retained.
+func ExplainPrunedBackups(backups []Backup, policies []RetentionPolicy, held []Backup) ([]RetentionExplanation, error) {
 	index := MakeIndex(backups)
 	chains := make(map[string][]Backup)
 
@@ -153,30 +394,257 @@ func GetPrunedBackups(backups []Backup, policies []RetentionPolicy) ([]Backup, e
 		}
 	}
 
+	explanations := make([]RetentionExplanation, 0, len(backups))
 	if len(policies) == 0 {
 		// Default policy for backups is to retain everything
+		for _, b := range backups {
+			explanations = append(explanations, RetentionExplanation{Name: b.FullName(), Retained: true, Reasons: []string{"no retention policy"}})
+		}
+		return explanations, nil
+	}
+
+	retained, err := ExplainRetentionPolicies(policies, subjects)
+	if err != nil {
+		return nil, err
+	}
+
+	dependencyReasons := make(map[string][]string)
+	for _, b := range backups {
+		if _, ok := retained[b.Name()]; !ok {
+			continue
+		}
+		for _, dep := range chains[b.Name()][1:] {
+			dependencyReasons[dep.Name()] = append(dependencyReasons[dep.Name()], "dependency of "+b.FullName())
+		}
+	}
+
+	heldSet := make(map[string]struct{})
+	for _, h := range held {
+		if _, ok := index[h.Name()]; !ok {
+			continue
+		}
+		heldSet[h.Name()] = struct{}{}
+
+		// Also retain what remains of the chain of orphan held backups
+		chain, _ := GetFullChain(h, index)
+		dependencyReasons[h.Name()] = append(dependencyReasons[h.Name()], "held")
+		for _, dep := range chain[1:] {
+			dependencyReasons[dep.Name()] = append(dependencyReasons[dep.Name()], "dependency of held "+h.FullName())
+		}
+	}
+
+	for _, b := range backups {
+		reasons := append(retained[b.Name()], dependencyReasons[b.Name()]...)
+		if len(reasons) > 0 {
+			_, isHeld := heldSet[b.Name()]
+			dependency := len(retained[b.Name()]) == 0 && !isHeld
+			explanations = append(explanations, RetentionExplanation{Name: b.FullName(), Retained: true, Reasons: reasons, Dependency: dependency})
+		} else if _, ok := chains[b.Name()]; !ok {
+			explanations = append(explanations, RetentionExplanation{Name: b.FullName(), Retained: false, Reasons: []string{"orphan (incomplete chain)"}})
+		} else {
+			explanations = append(explanations, RetentionExplanation{Name: b.FullName(), Retained: false})
+		}
+	}
+
+	return explanations, nil
+}
+
+// Get backups from a destination not retained by a given retention policy. Held backups, and the
+// backups they depend on, are always retained.
+func GetPrunedBackups(backups []Backup, policies []RetentionPolicy, held []Backup) ([]Backup, error) {
+	if len(policies) == 0 {
 		logrus.Warn("no retention policies set for destination, keeping everything")
 		return nil, nil
 	}
 
-	retained, err := ApplyRetentionPolicies(policies, subjects)
+	explanations, err := ExplainPrunedBackups(backups, policies, held)
 	if err != nil {
 		return nil, err
 	}
 
-	retainedChainFronts := make([]string, 0, len(retained))
-	for r := range retained {
-		retainedChainFronts = append(retainedChainFronts, r)
+	pruned := make([]Backup, 0, len(backups))
+	for i, b := range backups {
+		if !explanations[i].Retained {
+			pruned = append(pruned, b)
+		}
 	}
-	for _, r := range retainedChainFronts {
-		for _, b := range chains[r] {
-			retained[b.Name()] = struct{}{}
+
+	return pruned, nil
+}
+
+// Prune the oldest chains of retained backups until their total size fits in maxSize, updating
+// explanations (which must match backups, sorted from the most recent to the oldest). Chains
+// containing a held backup or the most recent full backup are never pruned. Return the total size
+// of retained backups.
+func ApplySizeBudget(backups []Backup, explanations []RetentionExplanation, held []Backup, sizes map[string]int64, maxSize int64) (int64, error) {
+	index := MakeIndex(backups)
+	roots := make([]Backup, len(backups))
+	protectedRoots := make(map[string]struct{})
+	latestFound, latestFullFound := false, false
+	var usage int64
+	for i, b := range backups {
+		chain, _ := GetFullChain(b, index)
+		roots[i] = chain[len(chain)-1]
+		if !explanations[i].Retained {
+			continue
+		}
+
+		size, ok := sizes[b.FullName()]
+		if !ok {
+			return 0, fmt.Errorf("unknown size for backup %s", b.FullName())
+		}
+		usage += size
+
+		// Never prune the most recent backup nor the most recent full backup
+		if !latestFound {
+			protectedRoots[roots[i].Name()] = struct{}{}
+			latestFound = true
+		}
+		if b.IsFull() && !latestFullFound {
+			protectedRoots[b.Name()] = struct{}{}
+			latestFullFound = true
 		}
 	}
 
-	pruned := make([]Backup, 0, len(backups)-len(retained))
-	for _, b := range backups {
-		if _, ok := retained[b.Name()]; !ok {
+	for _, h := range held {
+		if _, ok := index[h.Name()]; ok {
+			chain, _ := GetFullChain(h, index)
+			protectedRoots[chain[len(chain)-1].Name()] = struct{}{}
+		}
+	}
+
+	for i := len(backups) - 1; i >= 0 && usage > maxSize; i-- {
+		root := backups[i]
+		if roots[i].Name() != root.Name() || !explanations[i].Retained {
+			continue
+		}
+		if _, ok := protectedRoots[root.Name()]; ok {
+			continue
+		}
+
+		for j, b := range backups {
+			if roots[j].Name() == root.Name() && explanations[j].Retained {
+				usage -= sizes[b.FullName()]
+				explanations[j].Retained = false
+				explanations[j].Dependency = false
+				explanations[j].Reasons = []string{"oldest chain, pruned to fit MaxSize"}
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// Cost of keeping a chain of backups: a full backup and the retained backups depending on it
+type ChainCost struct {
+	Root             Backup
+	Backups          int   // Number of retained backups in the chain
+	Dependencies     int   // Number of backups retained only as dependencies of other backups
+	Size             int64 // Total size of the retained backups, if known
+	DependenciesSize int64 // Total size of the backups retained only as dependencies, if known
+}
+
+// Group retained backups by chain (explanations must match backups), from the most recent chain to
+// the oldest. sizes may be nil if the size of backups is unknown.
+func GetChainCosts(backups []Backup, explanations []RetentionExplanation, sizes map[string]int64) []ChainCost {
+	index := MakeIndex(backups)
+	costs := make(map[string]*ChainCost)
+	var roots []Backup
+	for i, b := range backups {
+		if !explanations[i].Retained {
+			continue
+		}
+
+		chain, _ := GetFullChain(b, index)
+		root := chain[len(chain)-1]
+		cost, ok := costs[root.Name()]
+		if !ok {
+			cost = &ChainCost{Root: root}
+			costs[root.Name()] = cost
+			roots = append(roots, root)
+		}
+
+		cost.Backups++
+		cost.Size += sizes[b.FullName()]
+		if explanations[i].Dependency {
+			cost.Dependencies++
+			cost.DependenciesSize += sizes[b.FullName()]
+		}
+	}
+
+	sort.Slice(roots, func(a, b int) bool {
+		return CompareBackups(roots[a], roots[b]) >= 0
+	})
+
+	res := make([]ChainCost, 0, len(roots))
+	for _, root := range roots {
+		res = append(res, *costs[root.Name()])
+	}
+	return res
+}
+
+// Explain which backups from a destination are retained by its retention policies and, if maxSize
+// is not zero, its size budget. Also return the total size of retained backups if maxSize is not
+// zero.
+func ExplainDestinationPruning(dst Destination, backups []Backup, policies []RetentionPolicy, maxSize int64) ([]RetentionExplanation, int64, error) {
+	return ExplainPendingDestinationPruning(dst, backups, policies, maxSize, nil)
+}
+
+// Like ExplainDestinationPruning, when some of the backups are not on the destination yet. Their
+// sizes, indexed by Backup.FullName(), are given by pendingSizes.
+func ExplainPendingDestinationPruning(dst Destination, backups []Backup, policies []RetentionPolicy, maxSize int64, pendingSizes map[string]int64) ([]RetentionExplanation, int64, error) {
+	held, err := ListHeldBackups(dst)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	explanations, err := ExplainPrunedBackups(backups, policies, held)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if maxSize == 0 {
+		return explanations, 0, nil
+	}
+
+	sizes, err := ListBackupSizes(dst)
+	if err != nil {
+		return nil, 0, err
+	}
+	for name, size := range pendingSizes {
+		sizes[name] = size
+	}
+
+	usage, err := ApplySizeBudget(backups, explanations, held, sizes, maxSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if usage > maxSize {
+		logrus.Warnf("backups still use %s after pruning, exceeding MaxSize (%s)", FormatSize(usage), FormatSize(maxSize))
+	} else {
+		logrus.Printf("backups use %s after pruning (MaxSize: %s)", FormatSize(usage), FormatSize(maxSize))
+	}
+
+	return explanations, usage, nil
+}
+
+// Get backups from a destination not retained by its retention policies and, if maxSize is not
+// zero, its size budget
+func GetDestinationPrunedBackups(dst Destination, backups []Backup, policies []RetentionPolicy, maxSize int64) ([]Backup, error) {
+	if len(policies) == 0 && maxSize == 0 {
+		logrus.Warn("no retention policies set for destination, keeping everything")
+		return nil, nil
+	}
+
+	explanations, _, err := ExplainDestinationPruning(dst, backups, policies, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := make([]Backup, 0, len(backups))
+	for i, b := range backups {
+		if !explanations[i].Retained {
 			pruned = append(pruned, b)
 		}
 	}
@@ -184,23 +652,17 @@ func GetPrunedBackups(backups []Backup, policies []RetentionPolicy) ([]Backup, e
 	return pruned, nil
 }
 
-// Get snapshots from a source not retained by a given retention policy
-func GetPrunedSnapshots(archives []Snapshot, bookmarks []Snapshot, policies []RetentionPolicy, state map[string]string) ([]Snapshot, []Snapshot, error) {
+// Explain which snapshots from a source are retained by a given retention policy, and why
+func ExplainPrunedSnapshots(archives []Snapshot, bookmarks []Snapshot, policies []RetentionPolicy, state map[string]string) ([]RetentionExplanation, []RetentionExplanation, error) {
 	subjects := make([]RetentionPolicySubject, 0, len(archives))
 	for _, a := range archives {
 		subjects = append(subjects, a)
 	}
 
-	var retainedArchives map[string]struct{}
-	if len(policies) == 0 {
-		// Default policy for snapshots is to retain nothing
-		retainedArchives = make(map[string]struct{})
-	} else {
-		var err error
-		retainedArchives, err = ApplyRetentionPolicies(policies, subjects)
-		if err != nil {
-			return nil, nil, err
-		}
+	// Default policy for snapshots is to retain nothing
+	retainedArchives, err := ExplainRetentionPolicies(policies, subjects)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	bookmarksSet := make(map[string]struct{})
@@ -210,24 +672,50 @@ func GetPrunedSnapshots(archives []Snapshot, bookmarks []Snapshot, policies []Re
 
 	// Retain bookmarks used by destinations
 	// Retain archives used by destinations, unless covered by a bookmark
-	retainedBookmarks := make(map[string]struct{})
-	for _, s := range state {
-		retainedBookmarks[s] = struct{}{}
+	retainedBookmarks := make(map[string][]string)
+	destinations := make([]string, 0, len(state))
+	for dst := range state {
+		destinations = append(destinations, dst)
+	}
+	sort.Strings(destinations)
+	for _, dst := range destinations {
+		s := state[dst]
+		reason := "last backup on destination " + dst
+		retainedBookmarks[s] = append(retainedBookmarks[s], reason)
 		if _, ok := bookmarksSet[s]; !ok {
-			retainedArchives[s] = struct{}{}
+			retainedArchives[s] = append(retainedArchives[s], reason)
+		}
+	}
+
+	explain := func(snapshots []Snapshot, retained map[string][]string) []RetentionExplanation {
+		explanations := make([]RetentionExplanation, 0, len(snapshots))
+		for _, s := range snapshots {
+			reasons := retained[s.Name()]
+			explanations = append(explanations, RetentionExplanation{Name: s.Name(), Retained: len(reasons) > 0, Reasons: reasons})
 		}
+		return explanations
+	}
+
+	return explain(archives, retainedArchives), explain(bookmarks, retainedBookmarks), nil
+}
+
+// Get snapshots from a source not retained by a given retention policy
+func GetPrunedSnapshots(archives []Snapshot, bookmarks []Snapshot, policies []RetentionPolicy, state map[string]string) ([]Snapshot, []Snapshot, error) {
+	archivesExplanations, bookmarksExplanations, err := ExplainPrunedSnapshots(archives, bookmarks, policies, state)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	prunedArchives := make([]Snapshot, 0, len(archives))
-	for _, a := range archives {
-		if _, ok := retainedArchives[a.Name()]; !ok {
+	for i, a := range archives {
+		if !archivesExplanations[i].Retained {
 			prunedArchives = append(prunedArchives, a)
 		}
 	}
 
 	prunedBookmarks := make([]Snapshot, 0, len(bookmarks))
-	for _, b := range bookmarks {
-		if _, ok := retainedBookmarks[b.Name()]; !ok {
+	for i, b := range bookmarks {
+		if !bookmarksExplanations[i].Retained {
 			prunedBookmarks = append(prunedBookmarks, b)
 		}
 	}
@@ -235,49 +723,56 @@ func GetPrunedSnapshots(archives []Snapshot, bookmarks []Snapshot, policies []Re
 	return prunedArchives, prunedBookmarks, nil
 }
 
-// Prune backups from a destinations according to a retention policy
-func PruneBackups(dst Destination, backups []Backup, policies []RetentionPolicy) error {
-	prunedBackups, err := GetPrunedBackups(backups, policies)
+// Prune backups from a destinations according to a retention policy and a size budget (if maxSize is not
+// zero), and return the removed backups
+func PruneBackups(dst Destination, backups []Backup, policies []RetentionPolicy, maxSize int64) ([]Backup, error) {
+	prunedBackups, err := GetDestinationPrunedBackups(dst, backups, policies, maxSize)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var removedBackups []Backup
 	for _, b := range prunedBackups {
 		log := logrus.WithFields(logrus.Fields{"backup": string(b.Snapshot)})
 		log.Printf("removing backup")
-		err = dst.RemoveBackup(b)
+		err = RemoveBackup(dst, b)
 		if err != nil {
 			log.Warnf("cannot prune backup: %v", err)
+			continue
 		}
+		removedBackups = append(removedBackups, b)
 	}
 
-	return nil
+	return removedBackups, nil
 }
 
-// Prune snapshots from a source accoruding to a retention policy
-func PruneSnapshots(src Source, policies []RetentionPolicy, state map[string]string) error {
+// Prune snapshots from a source accoruding to a retention policy, and return the removed snapshots
+func PruneSnapshots(src Source, policies []RetentionPolicy, state map[string]string) ([]Snapshot, error) {
 	bookmarks, err := SortedListBookmarks(src)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	archives, err := SortedListArchives(src)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	prunedArchives, prunedBookmarks, err := GetPrunedSnapshots(archives, bookmarks, policies, state)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var removedSnapshots []Snapshot
 	for _, s := range prunedArchives {
 		log := logrus.WithFields(logrus.Fields{"archive": string(s)})
 		log.Printf("deleting archive")
 		err = src.RemoveArchive(s)
 		if err != nil {
 			log.Warnf("cannot prune archive: %v", err)
+			continue
 		}
+		removedSnapshots = append(removedSnapshots, s)
 	}
 
 	for _, s := range prunedBookmarks {
@@ -286,8 +781,10 @@ func PruneSnapshots(src Source, policies []RetentionPolicy, state map[string]str
 		err = src.RemoveBookmark(s)
 		if err != nil {
 			log.Warnf("cannot prune bookmark: %v", err)
+			continue
 		}
+		removedSnapshots = append(removedSnapshots, s)
 	}
 
-	return nil
+	return removedSnapshots, nil
 }
@@ -3,6 +3,7 @@ package uback
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type parseRetentionPolicyTest struct {
@@ -25,14 +26,296 @@ func TestParseRetentionPolicy(t *testing.T) {
 		{s: "11=12", result: RetentionPolicy{Interval: 11, Count: 12, FullOnly: false}},
 	}
 
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calendarTests := []parseRetentionPolicyTest{
+		{s: "monthly=12:calendar", result: RetentionPolicy{Interval: 30 * 24 * 3600, Count: 12, CalendarUnit: 'm', Location: time.Local}},
+		{s: "1y=3:calendar:full", result: RetentionPolicy{Interval: 365 * 24 * 3600, Count: 3, FullOnly: true, CalendarUnit: 'y', Location: time.Local}},
+		{s: "weekly=4:calendar:tz=UTC", result: RetentionPolicy{Interval: 7 * 24 * 3600, Count: 4, CalendarUnit: 'w', Location: time.UTC}},
+		{s: "daily=7:calendar:first:tz=Europe/Paris", result: RetentionPolicy{Interval: 24 * 3600, Count: 7, CalendarUnit: 'd', CalendarFirst: true, Location: paris}},
+		{s: "hourly=24:calendar", result: RetentionPolicy{Interval: 3600, Count: 24, CalendarUnit: 'h', Location: time.Local}},
+	}
+	tests = append(tests, calendarTests...)
+
+	ruleTests := []parseRetentionPolicyTest{
+		{s: "within=30d", result: RetentionPolicy{Within: 30 * 24 * 3600}},
+		{s: "within=weekly:full", result: RetentionPolicy{Within: 7 * 24 * 3600, FullOnly: true}},
+		{s: "last=10", result: RetentionPolicy{Count: 10}},
+		{s: "last=3:full", result: RetentionPolicy{Count: 3, FullOnly: true}},
+		{s: "min-full=2", result: RetentionPolicy{Count: 2, FullOnly: true}},
+	}
+	tests = append(tests, ruleTests...)
+
 	for _, test := range tests {
 		result, err := ParseRetentionPolicy(test.s)
 		if err != nil {
 			t.Errorf("failed to parse retention policy: %v: %v", test.s, err)
-		} else if !reflect.DeepEqual(result, test.result) {
+			continue
+		}
+		if result.Location != nil && test.result.Location != nil && result.Location.String() == test.result.Location.String() {
+			result.Location = test.result.Location
+		}
+		if !reflect.DeepEqual(result, test.result) {
 			t.Errorf("do not match: %v %v (from %v)", test.result, result, test.s)
 		}
 	}
+
+	for _, s := range []string{"3m=4:calendar", "3600=4:calendar", "daily=7:first", "daily=7:tz=UTC", "daily=7:calendar:tz=Nowhere/Invalid", "daily=7:invalid", "within=0", "within=x", "within=1d:calendar", "last=x", "last=2:first", "min-full=2:full", "last=0", "last=-1", "min-full=0", "min-full=-2"} {
+		_, err := ParseRetentionPolicy(s)
+		if err == nil {
+			t.Errorf("expected an error when parsing %v", s)
+		}
+	}
+}
+
+func TestRetentionPolicyString(t *testing.T) {
+	for _, p := range []string{"1h=24", "3d=4", "1m=12:full", "11=12", "1d=7:calendar:first:tz=UTC", "1y=3:calendar:full", "within=2w", "last=5", "min-full=2"} {
+		policy, err := ParseRetentionPolicy(p)
+		if err != nil {
+			t.Errorf("%s: %v", p, err)
+		} else if policy.String() != p {
+			t.Errorf("expected: %v, got: %v", p, policy.String())
+		}
+	}
+}
+
+func TestCalendarRetentionPolicy(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	makeBackups := func(snapshots ...string) []RetentionPolicySubject {
+		var backups []RetentionPolicySubject
+		for _, s := range snapshots {
+			backups = append(backups, Backup{Snapshot: Snapshot(s), BaseSnapshot: nil})
+		}
+		return backups
+	}
+
+	tests := []struct {
+		name     string
+		backups  []RetentionPolicySubject
+		policy   RetentionPolicy
+		retained []int
+	}{
+		{
+			name: "monthly, last of each month",
+			backups: makeBackups(
+				"20210415T000000.000",
+				"20210401T000000.000",
+				"20210331T230000.000",
+				"20210301T000000.000",
+				"20210228T000000.000",
+				"20210201T000000.000",
+				"20210131T000000.000",
+			),
+			policy:   RetentionPolicy{Count: 3, CalendarUnit: 'm', Location: time.UTC},
+			retained: []int{0, 2, 4},
+		},
+		{
+			name: "monthly, first of each month",
+			backups: makeBackups(
+				"20210415T000000.000",
+				"20210401T000000.000",
+				"20210331T230000.000",
+				"20210301T000000.000",
+				"20210228T000000.000",
+				"20210201T000000.000",
+				"20210131T000000.000",
+			),
+			policy:   RetentionPolicy{Count: 3, CalendarUnit: 'm', CalendarFirst: true, Location: time.UTC},
+			retained: []int{1, 3, 5},
+		},
+		{
+			// Interval-based monthly policies would drift: 20210331T230000 is 30 days before 20210430T230000,
+			// but only 29 days after 20210301T000000
+			name: "monthly, timezone",
+			backups: makeBackups(
+				"20210430T230000.000", // 2021-05-01 01:00 in Paris
+				"20210331T230000.000", // 2021-04-01 01:00 in Paris
+				"20210301T000000.000", // 2021-03-01 01:00 in Paris
+				"20210228T230000.000", // 2021-03-01 00:00 in Paris
+				"20210131T230000.000", // 2021-02-01 00:00 in Paris
+			),
+			policy:   RetentionPolicy{Count: 12, CalendarUnit: 'm', CalendarFirst: true, Location: paris},
+			retained: []int{0, 1, 3, 4},
+		},
+		{
+			name: "monthly, UTC",
+			backups: makeBackups(
+				"20210430T230000.000",
+				"20210331T230000.000",
+				"20210301T000000.000",
+				"20210228T230000.000",
+				"20210131T230000.000",
+			),
+			policy:   RetentionPolicy{Count: 12, CalendarUnit: 'm', CalendarFirst: true, Location: time.UTC},
+			retained: []int{0, 2, 3, 4},
+		},
+		{
+			name: "daily",
+			backups: makeBackups(
+				"20210131T120000.000",
+				"20210131T000000.000",
+				"20210130T235959.000",
+				"20210130T000000.000",
+				"20210128T000000.000",
+			),
+			policy:   RetentionPolicy{Count: 3, CalendarUnit: 'd', Location: time.UTC},
+			retained: []int{0, 2, 4},
+		},
+		{
+			name: "hourly",
+			backups: makeBackups(
+				"20210131T125900.000",
+				"20210131T120100.000",
+				"20210131T115900.000",
+				"20210131T100000.000",
+			),
+			policy:   RetentionPolicy{Count: 2, CalendarUnit: 'h', Location: time.UTC},
+			retained: []int{0, 2},
+		},
+		{
+			// ISO weeks start on monday; 2021-01-03 is a sunday, in week 53 of 2020
+			name: "weekly",
+			backups: makeBackups(
+				"20210111T000000.000",
+				"20210110T000000.000",
+				"20210104T000000.000",
+				"20210103T000000.000",
+				"20201228T000000.000",
+				"20201227T000000.000",
+			),
+			policy:   RetentionPolicy{Count: 10, CalendarUnit: 'w', Location: time.UTC},
+			retained: []int{0, 1, 3, 5},
+		},
+		{
+			name: "yearly, first",
+			backups: makeBackups(
+				"20220601T000000.000",
+				"20220101T000000.000",
+				"20211231T000000.000",
+				"20210101T000000.000",
+				"20201231T000000.000",
+			),
+			policy:   RetentionPolicy{Count: 2, CalendarUnit: 'y', CalendarFirst: true, Location: time.UTC},
+			retained: []int{1, 3},
+		},
+		{
+			name: "full only",
+			backups: []RetentionPolicySubject{
+				Backup{Snapshot: "20210301T000000.000", BaseSnapshot: makeSnapshotPtr("20210215T000000.000")},
+				Backup{Snapshot: "20210215T000000.000", BaseSnapshot: nil},
+				Backup{Snapshot: "20210201T000000.000", BaseSnapshot: nil},
+				Backup{Snapshot: "20210115T000000.000", BaseSnapshot: makeSnapshotPtr("20210101T000000.000")},
+				Backup{Snapshot: "20210101T000000.000", BaseSnapshot: nil},
+			},
+			policy:   RetentionPolicy{Count: 2, FullOnly: true, CalendarUnit: 'm', Location: time.UTC},
+			retained: []int{1, 4},
+		},
+		{
+			name:     "no backups",
+			backups:  nil,
+			policy:   RetentionPolicy{Count: 2, CalendarUnit: 'm', Location: time.UTC},
+			retained: nil,
+		},
+	}
+
+	for _, test := range tests {
+		expectedRetained := make(map[string]struct{})
+		for _, i := range test.retained {
+			expectedRetained[test.backups[i].Name()] = struct{}{}
+		}
+
+		retained, err := ApplyRetentionPolicies([]RetentionPolicy{test.policy}, test.backups)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(retained, expectedRetained) {
+			t.Errorf("%s: expected: %v, got: %v", test.name, expectedRetained, retained)
+		}
+	}
+}
+
+func TestRetentionRules(t *testing.T) {
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC) }
+
+	backups := []Backup{
+		{Snapshot: "20210131T120000.000", BaseSnapshot: makeSnapshotPtr("20210131T000000.000")},
+		{Snapshot: "20210131T000000.000", BaseSnapshot: makeSnapshotPtr("20210130T000000.000")},
+		{Snapshot: "20210130T000000.000", BaseSnapshot: makeSnapshotPtr("20210120T000000.000")},
+		{Snapshot: "20210120T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210110T000000.000", BaseSnapshot: makeSnapshotPtr("20210101T000000.000")},
+		{Snapshot: "20210101T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20201201T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20201101T000000.000", BaseSnapshot: nil},
+	}
+
+	tests := []struct {
+		name     string
+		policies []string
+		pruned   []int
+	}{
+		{name: "within, retains chains", policies: []string{"within=1d"}, pruned: []int{4, 5, 6, 7}},
+		{name: "within, boundary", policies: []string{"within=12h"}, pruned: []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{name: "within, full", policies: []string{"within=30d:full"}, pruned: []int{0, 1, 2, 4, 5, 6, 7}},
+		{name: "last", policies: []string{"last=5"}, pruned: []int{6, 7}},
+		{name: "last, full", policies: []string{"last=2:full"}, pruned: []int{0, 1, 2, 4, 6, 7}},
+		{name: "min-full", policies: []string{"daily=1", "min-full=3"}, pruned: []int{4, 7}},
+		{name: "min-full, already satisfied", policies: []string{"weekly=10:full", "min-full=2"}, pruned: []int{0, 1, 2, 4}},
+		{name: "combined", policies: []string{"within=1d", "last=1:full", "monthly=3"}, pruned: []int{4, 7}},
+	}
+
+	for _, test := range tests {
+		var policies []RetentionPolicy
+		for _, p := range test.policies {
+			policy, err := ParseRetentionPolicy(p)
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+			policies = append(policies, policy)
+		}
+
+		expectedPruned := make([]Backup, 0)
+		for _, i := range test.pruned {
+			expectedPruned = append(expectedPruned, backups[i])
+		}
+
+		pruned, err := GetPrunedBackups(backups, policies, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !reflect.DeepEqual(pruned, expectedPruned) {
+			t.Errorf("%s: expected: %v, got: %v", test.name, expectedPruned, pruned)
+		}
+	}
+
+	// Snapshots
+	archives := []Snapshot{
+		"20210131T120000.000",
+		"20210131T000000.000",
+		"20210130T000000.000",
+		"20210120T000000.000",
+		"20210110T000000.000",
+	}
+	policies := []RetentionPolicy{
+		{Within: 24 * 3600},
+		{Count: 4, FullOnly: true},
+	}
+	prunedArchives, _, err := GetPrunedSnapshots(archives, nil, policies, nil)
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(prunedArchives, archives[4:]) {
+		t.Errorf("expected: %v, got: %v", archives[4:], prunedArchives)
+	}
+}
+
+func makeSnapshotPtr(s string) *Snapshot {
+	sn := Snapshot(s)
+	return &sn
 }
 
 func TestRetentionPolicy(t *testing.T) {
@@ -200,7 +483,7 @@ func TestPrunedBackups(t *testing.T) {
 	}
 	expectedPruned = []Backup{backups[4], backups[5]}
 
-	pruned, err = GetPrunedBackups(backups, policies)
+	pruned, err = GetPrunedBackups(backups, policies, nil)
 	if err != nil {
 		t.Error(err)
 	} else if !reflect.DeepEqual(pruned, expectedPruned) {
@@ -226,7 +509,29 @@ func TestPrunedBackups(t *testing.T) {
 	}
 	expectedPruned = []Backup{backups[0], backups[1], backups[2], backups[7], backups[8], backups[9], backups[10]}
 
-	pruned, err = GetPrunedBackups(backups, policies)
+	pruned, err = GetPrunedBackups(backups, policies, nil)
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(pruned, expectedPruned) {
+		t.Errorf("expected: %v, got: %v", expectedPruned, pruned)
+	}
+
+	// Held backups are retained with their chain, even orphans
+	backups = []Backup{
+		{Snapshot: "20210331T000000.000", BaseSnapshot: makeSnapshot("20210330T000000.000")},
+		{Snapshot: "20210330T000000.000", BaseSnapshot: makeSnapshot("20210329T000000.000")},
+		{Snapshot: "20210131T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210130T000000.000", BaseSnapshot: makeSnapshot("20210129T000000.000")},
+		{Snapshot: "20210129T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210128T000000.000", BaseSnapshot: nil},
+	}
+	policies = []RetentionPolicy{
+		{Interval: 24 * 3600, Count: 1, FullOnly: false},
+	}
+	held := []Backup{backups[0], backups[3], {Snapshot: "20200101T000000.000", BaseSnapshot: nil}}
+	expectedPruned = []Backup{backups[5]}
+
+	pruned, err = GetPrunedBackups(backups, policies, held)
 	if err != nil {
 		t.Error(err)
 	} else if !reflect.DeepEqual(pruned, expectedPruned) {
@@ -245,7 +550,7 @@ func TestPrunedBackups(t *testing.T) {
 	policies = nil
 	expectedPruned = nil
 
-	pruned, err = GetPrunedBackups(backups, policies)
+	pruned, err = GetPrunedBackups(backups, policies, nil)
 	if err != nil {
 		t.Error(err)
 	} else if !reflect.DeepEqual(pruned, expectedPruned) {
@@ -253,6 +558,35 @@ func TestPrunedBackups(t *testing.T) {
 	}
 }
 
+func TestExplainPrunedBackups(t *testing.T) {
+	backups := []Backup{
+		{Snapshot: "20210131T000000.000", BaseSnapshot: makeSnapshotPtr("20210130T000000.000")},
+		{Snapshot: "20210130T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210129T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210128T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210127T000000.000", BaseSnapshot: makeSnapshotPtr("20210126T000000.000")},
+	}
+	policies := []RetentionPolicy{
+		{Interval: 24 * 3600, Count: 1},
+		{Count: 1, FullOnly: true},
+	}
+	held := []Backup{backups[2]}
+	expected := []RetentionExplanation{
+		{Name: "20210131T000000.000-from-20210130T000000.000", Retained: true, Reasons: []string{"1d=1"}},
+		{Name: "20210130T000000.000-full", Retained: true, Reasons: []string{"min-full=1", "dependency of 20210131T000000.000-from-20210130T000000.000"}},
+		{Name: "20210129T000000.000-full", Retained: true, Reasons: []string{"held"}},
+		{Name: "20210128T000000.000-full", Retained: false},
+		{Name: "20210127T000000.000-from-20210126T000000.000", Retained: false, Reasons: []string{"orphan (incomplete chain)"}},
+	}
+
+	explanations, err := ExplainPrunedBackups(backups, policies, held)
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(explanations, expected) {
+		t.Errorf("expected: %v, got: %v", expected, explanations)
+	}
+}
+
 func TestPruneArchives(t *testing.T) {
 	var archives []Snapshot
 	var policies []RetentionPolicy
@@ -436,3 +770,113 @@ func TestPruneSnapshots(t *testing.T) {
 		t.Errorf("expected: %v, got: %v", expectedPrunedBookmarks, prunedBookmarks)
 	}
 }
+
+func TestExplainPrunedSnapshots(t *testing.T) {
+	archives := []Snapshot{"20210131T000000.000", "20210130T000000.000", "20210129T000000.000"}
+	bookmarks := []Snapshot{"20210130T000000.000"}
+	policies := []RetentionPolicy{{Interval: 24 * 3600, Count: 1}}
+	state := map[string]string{"a": "20210130T000000.000", "b": "20210129T000000.000"}
+	expectedArchives := []RetentionExplanation{
+		{Name: "20210131T000000.000", Retained: true, Reasons: []string{"1d=1"}},
+		{Name: "20210130T000000.000", Retained: false},
+		{Name: "20210129T000000.000", Retained: true, Reasons: []string{"last backup on destination b"}},
+	}
+	expectedBookmarks := []RetentionExplanation{
+		{Name: "20210130T000000.000", Retained: true, Reasons: []string{"last backup on destination a"}},
+	}
+
+	archivesExplanations, bookmarksExplanations, err := ExplainPrunedSnapshots(archives, bookmarks, policies, state)
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(archivesExplanations, expectedArchives) {
+		t.Errorf("expected: %v, got: %v", expectedArchives, archivesExplanations)
+	} else if !reflect.DeepEqual(bookmarksExplanations, expectedBookmarks) {
+		t.Errorf("expected: %v, got: %v", expectedBookmarks, bookmarksExplanations)
+	}
+}
+
+func TestSizeBudget(t *testing.T) {
+	backups := []Backup{
+		{Snapshot: "20210106T000000.000", BaseSnapshot: makeSnapshotPtr("20210105T000000.000")},
+		{Snapshot: "20210105T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210104T000000.000", BaseSnapshot: makeSnapshotPtr("20210103T000000.000")},
+		{Snapshot: "20210103T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210102T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210101T000000.000", BaseSnapshot: nil},
+	}
+	sizes := make(map[string]int64)
+	for _, b := range backups {
+		sizes[b.FullName()] = 10
+	}
+	policies := []RetentionPolicy{{Interval: 24 * 3600, Count: 10}}
+
+	tests := []struct {
+		maxSize  int64
+		held     []Backup
+		usage    int64
+		retained []bool
+	}{
+		{maxSize: 100, usage: 60, retained: []bool{true, true, true, true, true, true}},
+		{maxSize: 40, usage: 40, retained: []bool{true, true, true, true, false, false}},
+		{maxSize: 30, usage: 20, retained: []bool{true, true, false, false, false, false}},
+		{maxSize: 30, held: []Backup{backups[4]}, usage: 30, retained: []bool{true, true, false, false, true, false}},
+		{maxSize: 0, usage: 20, retained: []bool{true, true, false, false, false, false}},
+	}
+
+	for i, test := range tests {
+		explanations, err := ExplainPrunedBackups(backups, policies, test.held)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		usage, err := ApplySizeBudget(backups, explanations, test.held, sizes, test.maxSize)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if usage != test.usage {
+			t.Errorf("%d: expected usage: %v, got: %v", i, test.usage, usage)
+		}
+		for j, e := range explanations {
+			if e.Retained != test.retained[j] {
+				t.Errorf("%d: %s: expected retained: %v, got: %v", i, e.Name, test.retained[j], e.Retained)
+			}
+		}
+	}
+
+	delete(sizes, backups[0].FullName())
+	explanations, _ := ExplainPrunedBackups(backups, policies, nil)
+	if _, err := ApplySizeBudget(backups, explanations, nil, sizes, 10); err == nil {
+		t.Errorf("expected error for unknown size")
+	}
+}
+
+func TestChainCosts(t *testing.T) {
+	backups := []Backup{
+		{Snapshot: "20210104T000000.000", BaseSnapshot: makeSnapshotPtr("20210103T000000.000")},
+		{Snapshot: "20210103T000000.000", BaseSnapshot: makeSnapshotPtr("20210102T000000.000")},
+		{Snapshot: "20210102T000000.000", BaseSnapshot: nil},
+		{Snapshot: "20210101T000000.000", BaseSnapshot: nil},
+	}
+	sizes := map[string]int64{
+		backups[0].FullName(): 1,
+		backups[1].FullName(): 2,
+		backups[2].FullName(): 4,
+		backups[3].FullName(): 8,
+	}
+	policies := []RetentionPolicy{{Count: 1}, {Count: 2, FullOnly: true}}
+
+	explanations, err := ExplainPrunedBackups(backups, policies, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ChainCost{
+		{Root: backups[2], Backups: 3, Dependencies: 1, Size: 7, DependenciesSize: 2},
+		{Root: backups[3], Backups: 1, Dependencies: 0, Size: 8, DependenciesSize: 0},
+	}
+	costs := GetChainCosts(backups, explanations, sizes)
+	if !reflect.DeepEqual(costs, expected) {
+		t.Errorf("expected: %v, got: %v", expected, costs)
+	}
+}
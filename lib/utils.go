@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"filippo.io/age"
@@ -65,6 +68,16 @@ func (b Backup) Filename() string {
 	return b.FullName() + ".ubkp"
 }
 
+// Return the file name of the catalog of the backup
+func (b Backup) CatalogFilename() string {
+	return b.FullName() + ".ubkc"
+}
+
+// Return the file name of the marker holding the backup
+func (b Backup) HoldFilename() string {
+	return b.FullName() + ".ubkh"
+}
+
 // Compare backups by the date of their snapshot
 func CompareBackups(a, b Backup) int {
 	return CompareSnapshots(a.Snapshot, b.Snapshot)
@@ -136,6 +149,119 @@ func ParseBackupFilename(f string, requireExt bool) (Backup, error) {
 	return Backup{Snapshot: Snapshot(m[1]), BaseSnapshot: &baseSnapshot}, nil
 }
 
+// Check if a file name is the name of a catalog (see Backup.CatalogFilename)
+func IsCatalogFilename(f string) bool {
+	return strings.HasSuffix(f, ".ubkc")
+}
+
+// Parse the file name of a catalog into the backup it describes
+func ParseCatalogFilename(f string) (Backup, error) {
+	if !IsCatalogFilename(f) {
+		return Backup{}, fmt.Errorf("cannot parse catalog filename: %s", f)
+	}
+	return ParseBackupFilename(strings.TrimSuffix(f, ".ubkc"), false)
+}
+
+// Check if a file name is the name of a hold marker (see Backup.HoldFilename)
+func IsHoldFilename(f string) bool {
+	return strings.HasSuffix(f, ".ubkh")
+}
+
+// Parse the file name of a hold marker into the backup it holds
+func ParseHoldFilename(f string) (Backup, error) {
+	if !IsHoldFilename(f) {
+		return Backup{}, fmt.Errorf("cannot parse hold filename: %s", f)
+	}
+	return ParseBackupFilename(strings.TrimSuffix(f, ".ubkh"), false)
+}
+
+// Check if a file name is the name of a file stored next to backups (catalog or hold marker)
+func IsMetadataFilename(f string) bool {
+	return IsCatalogFilename(f) || IsHoldFilename(f)
+}
+
+// List held backups of a destination, or nothing if the destination does not support holds
+func ListHeldBackups(dst Destination) ([]Backup, error) {
+	if hd, ok := dst.(HoldDestination); ok {
+		return hd.ListHolds()
+	}
+	return nil, nil
+}
+
+// Return the size in bytes of each backup of a destination (see SizedDestination)
+func ListBackupSizes(dst Destination) (map[string]int64, error) {
+	if sd, ok := dst.(SizedDestination); ok {
+		return sd.ListBackupSizes()
+	}
+	return nil, fmt.Errorf("destination does not support size reporting")
+}
+
+// Add the size of a file stored on a destination to the size of the backup it belongs to (the backup
+// itself or its catalog). Other files are ignored.
+func AddBackupFileSize(sizes map[string]int64, f string, size int64) {
+	var backup Backup
+	var err error
+	if IsCatalogFilename(f) {
+		backup, err = ParseCatalogFilename(f)
+	} else if !IsMetadataFilename(f) {
+		backup, err = ParseBackupFilename(f, true)
+	} else {
+		return
+	}
+	if err == nil {
+		sizes[backup.FullName()] += size
+	}
+}
+
+// Parse a size in bytes, optionally followed by a K, M, G or T unit (powers of 1024)
+func ParseSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B"), "I")
+	if len(s) == 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+
+	var multiplier int64 = 1
+	if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+		multiplier = 1 << (10 * (i + 1))
+		s = s[:len(s)-1]
+	}
+
+	result, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || result < 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	if result > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size too large: %s", size)
+	}
+
+	return result * multiplier, nil
+}
+
+// Format a size in bytes using the largest unit accepted by ParseSize
+func FormatSize(size int64) string {
+	units := "KMGT"
+	for i := len(units) - 1; i >= 0; i-- {
+		if unit := int64(1) << (10 * (i + 1)); size >= unit {
+			return strconv.FormatFloat(float64(size)/float64(unit), 'f', 1, 64) + string(units[i])
+		}
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// Remove a backup from a destination, and its catalog if the destination supports them
+func RemoveBackup(dst Destination, backup Backup) error {
+	err := dst.RemoveBackup(backup)
+	if err != nil {
+		return err
+	}
+
+	if cd, ok := dst.(CatalogDestination); ok {
+		return cd.RemoveCatalog(backup)
+	}
+
+	return nil
+}
+
 // This should really be in the standard library...
 func CopyFile(dst, src string) error {
 	srcF, err := os.Open(src)
@@ -162,7 +288,8 @@ func CopyFile(dst, src string) error {
 // Intended to be used in a source CreateBackup(). If the created backup data is simply given by a command
 // stdout, make a ReadCloser from an exec.Command stdout. When the subprocess is done, call finalize with
 // the result of cmd.Wait() as an argument. The result of finalize will be the error returned by the reader
-// on the next read/close, until the error is nil (then EOF will be returned on next read)
+// on the next read/close, until the error is nil (then EOF will be returned on next read). Closing the reader
+// before the end of the data kills the subprocess, and waits for finalize to return.
 func WrapSourceCommand(backup Backup, cmd *exec.Cmd, finalize func(err error) error) (Backup, io.ReadCloser, error) {
 	logrus.Printf("running: %v", cmd.String())
 
@@ -176,7 +303,9 @@ func WrapSourceCommand(backup Backup, cmd *exec.Cmd, finalize func(err error) er
 		return Backup{}, nil, err
 	}
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		if finalize == nil {
 			pw.CloseWithError(cmd.Wait())
 		} else {
@@ -184,7 +313,7 @@ func WrapSourceCommand(backup Backup, cmd *exec.Cmd, finalize func(err error) er
 		}
 	}()
 
-	return backup, pr, nil
+	return backup, WrapCommandCloser(pr, cmd, done), nil
 }
 
 // Index a list of backups by the name of the backup snapshot
@@ -266,3 +395,59 @@ func RunCommand(log *logrus.Entry, cmd *exec.Cmd) error {
 	log.Printf("starting: %s", cmd.String())
 	return cmd.Run()
 }
+
+type cleanupReadCloser struct {
+	io.ReadCloser
+	cleanup func() error
+	once    sync.Once
+}
+
+func (c *cleanupReadCloser) runCleanup() error {
+	var err error
+	c.once.Do(func() {
+		err = c.cleanup()
+	})
+	return err
+}
+
+func (c *cleanupReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err != nil {
+		cleanupErr := c.runCleanup()
+		if err == io.EOF && cleanupErr != nil {
+			err = cleanupErr
+		}
+	}
+	return n, err
+}
+
+func (c *cleanupReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	cleanupErr := c.runCleanup()
+	if err == nil {
+		err = cleanupErr
+	}
+	return err
+}
+
+// Intended to be used in a source CreateBackup(). Call cleanup once the backup data has been fully
+// read or the reader has been closed, whatever comes first. An error returned by cleanup is reported
+// instead of EOF
+func WrapCleanup(rc io.ReadCloser, cleanup func() error) io.ReadCloser {
+	return &cleanupReadCloser{ReadCloser: rc, cleanup: cleanup}
+}
+
+// Check if the path of a backup entry, or the path of one of its parents, matches one of the glob
+// patterns (see path.Match)
+func MatchBackupPath(patterns []string, name string) bool {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	for _, pattern := range patterns {
+		pattern = strings.Trim(path.Clean("/"+pattern), "/")
+		for p := name; p != "." && p != ""; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package uback
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"0":        0,
+		"1234":     1234,
+		"2K":       2 * 1024,
+		"3m":       3 * 1024 * 1024,
+		"10G":      10 * 1024 * 1024 * 1024,
+		"10GiB":    10 * 1024 * 1024 * 1024,
+		"1TB":      1024 * 1024 * 1024 * 1024,
+		"8388607T": 8388607 * 1024 * 1024 * 1024 * 1024,
+	}
+
+	for s, expected := range tests {
+		result, err := ParseSize(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if result != expected {
+			t.Errorf("%s: expected: %v, got: %v", s, expected, result)
+		}
+	}
+
+	for _, s := range []string{"", "G", "-1", "1X", "1.5G", "99999999999T", "9223372036854775807K"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func TestAddBackupFileSize(t *testing.T) {
+	sizes := make(map[string]int64)
+	AddBackupFileSize(sizes, "20210101T000000.000-full.ubkp", 100)
+	AddBackupFileSize(sizes, "20210101T000000.000-full.ubkc", 10)
+	AddBackupFileSize(sizes, "20210101T000000.000-full.ubkh", 1)
+	AddBackupFileSize(sizes, "20210102T000000.000-from-20210101T000000.000.ubkp", 20)
+	AddBackupFileSize(sizes, "invalid", 1000)
+
+	if len(sizes) != 2 || sizes["20210101T000000.000-full"] != 110 || sizes["20210102T000000.000-from-20210101T000000.000"] != 20 {
+		t.Errorf("unexpected sizes: %v", sizes)
+	}
+}
@@ -0,0 +1,338 @@
+package sources
+
+import (
+	uback "github.com/sloonz/uback/lib"
+
+	"archive/tar"
+	"bufio"
+	"bytes"
+	_ "embed" // required for go:embed
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrBinlogMariadbCommand = errors.New("binlog source: missing or invalid mariadb command")
+	ErrBinlogStopPosition   = errors.New("binlog source: invalid StopPosition option")
+	ErrBinlogStopGtid       = errors.New("binlog source: invalid StopGtid option")
+	ErrBinlogStopConflict   = errors.New("binlog source: StopPosition and StopGtid options cannot be combined")
+	binlogLog               = logrus.WithFields(logrus.Fields{
+		"source": "binlog",
+	})
+
+	//go:embed scripts/binlog-replay.sh
+	binlogReplayScript []byte
+)
+
+type binlogSource struct {
+	options        *uback.Options
+	snapshotsPath  string
+	binlogPath     string
+	mariadbCommand []string
+	stopDatetime   string
+	stopPosition   string
+	stopGtid       string
+}
+
+func newBinlogSource(options *uback.Options) (uback.Source, error) {
+	snapshotsPath := options.String["SnapshotsPath"]
+	if snapshotsPath == "" {
+		binlogLog.Warnf("SnapshotsPath option missing, incremental backups will be impossible")
+	} else {
+		err := os.MkdirAll(snapshotsPath, 0777)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mariadbCommand := options.GetCommand("MariadbCommand", []string{"mariadb"})
+	if len(mariadbCommand) == 0 {
+		return nil, ErrBinlogMariadbCommand
+	}
+
+	return &binlogSource{
+		options:        options,
+		snapshotsPath:  snapshotsPath,
+		binlogPath:     options.GetString("BinlogPath", "/var/lib/mysql"),
+		mariadbCommand: mariadbCommand,
+	}, nil
+}
+
+func newBinlogSourceForRestoration(options *uback.Options) (uback.Source, error) {
+	stopPosition := options.String["StopPosition"]
+	if stopPosition != "" && !regexp.MustCompile(`^[0-9]+$`).MatchString(stopPosition) {
+		return nil, ErrBinlogStopPosition
+	}
+
+	// mariadb-binlog refuses to mix a GTID and a file offset, so a GTID stop position also
+	// requires a GTID start position
+	stopGtid := options.String["StopGtid"]
+	if stopGtid != "" && !regexp.MustCompile(`^[0-9]+-[0-9]+-[0-9]+(,[0-9]+-[0-9]+-[0-9]+)*$`).MatchString(stopGtid) {
+		return nil, ErrBinlogStopGtid
+	}
+
+	if stopPosition != "" && stopGtid != "" {
+		return nil, ErrBinlogStopConflict
+	}
+
+	return &binlogSource{
+		stopDatetime: options.String["StopDatetime"],
+		stopPosition: stopPosition,
+		stopGtid:     stopGtid,
+	}, nil
+}
+
+// Part of uback.Source interface
+func (s *binlogSource) ListArchives() ([]uback.Snapshot, error) {
+	return nil, nil
+}
+
+// Part of uback.Source interface
+func (s *binlogSource) ListBookmarks() ([]uback.Snapshot, error) {
+	if s.snapshotsPath == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(s.snapshotsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []uback.Snapshot
+
+	re := regexp.MustCompile(fmt.Sprintf("^%s$", uback.SnapshotRe))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") || entry.IsDir() {
+			continue
+		}
+
+		if !re.MatchString(entry.Name()) {
+			binlogLog.WithFields(logrus.Fields{"file": entry.Name()}).Warnf("invalid snapshot name")
+			continue
+		}
+
+		snapshots = append(snapshots, uback.Snapshot(entry.Name()))
+	}
+
+	return snapshots, nil
+}
+
+// Part of uback.Source interface
+func (s *binlogSource) RemoveArchive(snapshot uback.Snapshot) error {
+	panic("should never happen")
+}
+
+// Part of uback.Source interface
+func (s *binlogSource) RemoveBookmark(snapshot uback.Snapshot) error {
+	if s.snapshotsPath == "" {
+		return nil
+	}
+	return os.Remove(path.Join(s.snapshotsPath, string(snapshot)))
+}
+
+// Numeric extension of a binary log name
+func binlogNumber(name string) (uint64, bool) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(path.Ext(name), "."), 10, 64)
+	return n, err == nil
+}
+
+// Compare two binary log names by their numeric extension, which may grow a digit (mysql-bin.999999
+// is followed by mysql-bin.1000000)
+func compareBinlogs(a, b string) int {
+	na, okA := binlogNumber(a)
+	nb, okB := binlogNumber(b)
+	if !okA || !okB || na == nb {
+		return strings.Compare(a, b)
+	}
+	if na < nb {
+		return -1
+	}
+	return 1
+}
+
+// Rotate the binary log and return the list of closed binary logs
+func (s *binlogSource) closedBinlogs() ([]string, error) {
+	cmd := uback.BuildCommand(s.mariadbCommand, "-BNe", "FLUSH BINARY LOGS; SHOW BINARY LOGS")
+	cmd.Stdout = nil
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list binary logs: %v", err)
+	}
+
+	var binlogs []string
+	scanner := bufio.NewScanner(bytes.NewBuffer(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			binlogs = append(binlogs, fields[0])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(binlogs) == 0 {
+		return nil, errors.New("binlog source: no binary log found, is log_bin enabled?")
+	}
+
+	// The last one is the one currently written by the server
+	return binlogs[:len(binlogs)-1], nil
+}
+
+func (s *binlogSource) writeTar(w io.Writer, binlogs []string) error {
+	tw := tar.NewWriter(w)
+	for _, binlog := range binlogs {
+		err := func() error {
+			f, err := os.Open(path.Join(s.binlogPath, binlog))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			st, err := f.Stat()
+			if err != nil {
+				return err
+			}
+
+			hdr, err := tar.FileInfoHeader(st, "")
+			if err != nil {
+				return err
+			}
+
+			err = tw.WriteHeader(hdr)
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(tw, f)
+			return err
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Part of uback.Source interface
+func (s *binlogSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io.ReadCloser, error) {
+	snapshot := time.Now().UTC().Format(uback.SnapshotTimeFormat)
+	tmpSnapshotPath := path.Join(s.snapshotsPath, fmt.Sprintf("_tmp-%s", snapshot))
+	finalSnapshotPath := path.Join(s.snapshotsPath, snapshot)
+
+	if s.snapshotsPath == "" {
+		baseSnapshot = nil
+	}
+
+	// The bookmark contains the name of the last archived binary log
+	lastArchived := ""
+	if baseSnapshot != nil {
+		data, err := os.ReadFile(path.Join(s.snapshotsPath, baseSnapshot.Name()))
+		if err != nil {
+			binlogLog.WithFields(logrus.Fields{"snapshot": baseSnapshot.Name()}).Warnf("failed to read base snapshot (%v), forcing full backup", err)
+			baseSnapshot = nil
+		} else {
+			lastArchived = strings.TrimSpace(string(data))
+		}
+	}
+
+	closedBinlogs, err := s.closedBinlogs()
+	if err != nil {
+		return uback.Backup{}, nil, err
+	}
+
+	var binlogs []string
+	for _, binlog := range closedBinlogs {
+		if lastArchived == "" || compareBinlogs(binlog, lastArchived) > 0 {
+			binlogs = append(binlogs, binlog)
+		}
+	}
+
+	// If binary logs following the last archived one have been purged, the incremental chain
+	// would have a hole
+	if lastArchived != "" && len(binlogs) > 0 {
+		last, okLast := binlogNumber(lastArchived)
+		first, okFirst := binlogNumber(binlogs[0])
+		if !okLast || !okFirst || first != last+1 {
+			binlogLog.WithFields(logrus.Fields{"snapshot": baseSnapshot.Name()}).Warnf("binary logs between %s and %s are missing, forcing full backup", lastArchived, binlogs[0])
+			baseSnapshot = nil
+			binlogs = closedBinlogs
+		}
+	}
+	if len(binlogs) > 0 {
+		lastArchived = binlogs[len(binlogs)-1]
+	}
+
+	backup := uback.Backup{Snapshot: uback.Snapshot(snapshot), BaseSnapshot: baseSnapshot}
+	binlogLog.Printf("creating backup: %s", backup.Filename())
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := s.writeTar(pw, binlogs)
+		if err == nil && s.snapshotsPath != "" {
+			err = os.WriteFile(tmpSnapshotPath, []byte(lastArchived+"\n"), 0666)
+			if err == nil {
+				err = os.Rename(tmpSnapshotPath, finalSnapshotPath)
+			}
+			if err != nil {
+				os.Remove(tmpSnapshotPath)
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// When closed early, wait for the archive to be aborted and the temporary bookmark to be removed
+	return backup, uback.WrapCleanup(pr, func() error {
+		<-done
+		return nil
+	}), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Part of uback.Source interface
+func (s *binlogSource) RestoreBackup(targetDir string, backup uback.Backup, data io.Reader) error {
+	restoreDir := path.Join(targetDir, backup.Snapshot.Name())
+	err := os.RemoveAll(restoreDir)
+	if err != nil {
+		return err
+	}
+
+	if backup.BaseSnapshot == nil {
+		err = os.MkdirAll(restoreDir, 0777)
+	} else {
+		err = os.Rename(path.Join(targetDir, backup.BaseSnapshot.Name()), restoreDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("tar", "-x", "-C", restoreDir)
+	cmd.Stdin = data
+	err = uback.RunCommand(binlogLog, cmd)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path.Join(restoreDir, "binlog-replay.sh"), binlogReplayScript, 0777)
+	if err != nil {
+		return err
+	}
+
+	conf := fmt.Sprintf("STOP_DATETIME=%s\nSTOP_POSITION=%s\nSTOP_GTID=%s\n", shellQuote(s.stopDatetime), shellQuote(s.stopPosition), shellQuote(s.stopGtid))
+	return os.WriteFile(path.Join(restoreDir, "binlog-replay.conf"), []byte(conf), 0666)
+}
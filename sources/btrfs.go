@@ -174,8 +174,12 @@ func (s *btrfsSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup,
 	}
 	args = append(args, tmpSnapshotPath)
 	return uback.WrapSourceCommand(backup, uback.BuildCommand(s.sendCommand, args...), func(err error) error {
-		if !reused && err != nil {
-			_ = uback.RunCommand(btrfsLog, uback.BuildCommand(s.deleteCommand, tmpSnapshotPath))
+		if err != nil {
+			if reused {
+				_ = os.Rename(tmpSnapshotPath, finalSnapshotPath)
+			} else {
+				_ = uback.RunCommand(btrfsLog, uback.BuildCommand(s.deleteCommand, tmpSnapshotPath))
+			}
 			return err
 		}
 		return os.Rename(tmpSnapshotPath, finalSnapshotPath)
@@ -193,3 +197,19 @@ func (s *btrfsSource) RestoreBackup(targetDir string, backup uback.Backup, data
 
 	return os.Rename(path.Join(targetDir, "_tmp-"+backup.Snapshot.Name()), path.Join(targetDir, backup.Snapshot.Name()))
 }
+
+// Part of uback.SizeEstimator interface. This is an approximation based on the size of the files
+// changed since the base snapshot, not on the blocks actually sent by btrfs send ; btrfs send
+// --no-data would require a snapshot of the current state.
+func (s *btrfsSource) EstimateBackupSize(baseSnapshot *uback.Snapshot) (int64, error) {
+	var since time.Time
+	if baseSnapshot != nil && s.snapshotsPath != "" {
+		var err error
+		since, err = baseSnapshot.Time()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return estimateTreeSize(btrfsLog, s.basePath, since, nil)
+}
@@ -5,7 +5,6 @@ import (
 
 	"bufio"
 	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,7 +13,6 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/gobuffalo/flect"
 	"github.com/sirupsen/logrus"
 )
 
@@ -37,23 +35,17 @@ func newCommandSource(options *uback.Options) (uback.Source, string, error) {
 		return nil, "", ErrCommandMissing
 	}
 
-	env := os.Environ()
-	for k, v := range options.String {
-		env = append(env, fmt.Sprintf("UBACK_OPT_%s=%s", flect.New(k).Underscore().ToUpper().String(), v))
-	}
-	for k, v := range options.StrSlice {
-		jsonVal, err := json.Marshal(v)
-		if err != nil {
-			return nil, "", err
-		}
-		env = append(env, fmt.Sprintf("UBACK_SOPT_%s=%s", flect.New(k).Underscore().ToUpper().String(), string(jsonVal)))
+	optionsEnv, err := options.Environment("UBACK_")
+	if err != nil {
+		return nil, "", err
 	}
+	env := append(os.Environ(), optionsEnv...)
 
 	buf := bytes.NewBuffer(nil)
 	cmd := uback.BuildCommand(command, "source", "type")
 	cmd.Stdout = buf
 	cmd.Env = env
-	err := uback.RunCommand(commandLog, cmd)
+	err = uback.RunCommand(commandLog, cmd)
 	if err != nil {
 		return nil, "", err
 	}
@@ -157,7 +149,9 @@ func (s *commandSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup
 		return uback.Backup{}, nil, err
 	}
 
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		pw.CloseWithError(cmd.Wait())
 	}()
 
@@ -174,7 +168,10 @@ func (s *commandSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup
 		return uback.Backup{}, nil, err
 	}
 
-	return backup, io.NopCloser(br), nil
+	return backup, uback.WrapCommandCloser(struct {
+		io.Reader
+		io.Closer
+	}{br, pr}, cmd, done), nil
 }
 
 // Part of uback.Source interface
@@ -0,0 +1,423 @@
+package sources
+
+import (
+	uback "github.com/sloonz/uback/lib"
+
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrContainerVolume        = errors.New("container-volume source: missing volume")
+	ErrContainerVolumeCommand = errors.New("container-volume source: missing or invalid command")
+	ErrContainerVolumeName    = errors.New("container-volume source: VolumeName cannot be used with multiple volumes")
+	ErrContainerNoContainer   = errors.New("container-volume source: Pause, @ExecBefore and @ExecAfter require the Container option")
+	containerVolumeLog        = logrus.WithFields(logrus.Fields{
+		"source": "container-volume",
+	})
+)
+
+type containerVolumeSource struct {
+	options     *uback.Options
+	volumes     []string
+	command     []string
+	usePodman   bool
+	helperImage string
+	container   string
+	pause       bool
+	execBefore  []string
+	execAfter   []string
+
+	// Restoration options
+	restoreToVolume bool
+	volumeName      string
+}
+
+func newContainerVolumeCommand(options *uback.Options) ([]string, bool, error) {
+	usePodman, err := options.GetBoolean("UsePodman", true)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defaultCommand := []string{"podman"}
+	if !usePodman {
+		defaultCommand = []string{"docker"}
+	}
+
+	command := options.GetCommand("Command", defaultCommand)
+	if len(command) == 0 {
+		return nil, false, ErrContainerVolumeCommand
+	}
+
+	return command, usePodman, nil
+}
+
+func newContainerVolumeSource(options *uback.Options) (uback.Source, error) {
+	volumes := options.StrSlice["Volume"]
+	if len(volumes) == 0 {
+		return nil, ErrContainerVolume
+	}
+
+	command, usePodman, err := newContainerVolumeCommand(options)
+	if err != nil {
+		return nil, err
+	}
+
+	pause, err := options.GetBoolean("Pause", false)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &containerVolumeSource{
+		options:     options,
+		volumes:     volumes,
+		command:     command,
+		usePodman:   usePodman,
+		helperImage: options.GetString("HelperImage", "docker.io/library/alpine"),
+		container:   options.String["Container"],
+		pause:       pause,
+		execBefore:  options.StrSlice["ExecBefore"],
+		execAfter:   options.StrSlice["ExecAfter"],
+	}
+
+	if s.container == "" && (s.pause || len(s.execBefore) > 0 || len(s.execAfter) > 0) {
+		return nil, ErrContainerNoContainer
+	}
+
+	return s, nil
+}
+
+func newContainerVolumeSourceForRestoration(options *uback.Options) (uback.Source, error) {
+	command, usePodman, err := newContainerVolumeCommand(options)
+	if err != nil {
+		return nil, err
+	}
+
+	restoreToVolume, err := options.GetBoolean("RestoreToVolume", false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &containerVolumeSource{
+		command:         command,
+		usePodman:       usePodman,
+		helperImage:     options.GetString("HelperImage", "docker.io/library/alpine"),
+		restoreToVolume: restoreToVolume,
+		volumeName:      options.String["VolumeName"],
+	}, nil
+}
+
+// Part of uback.Source interface
+func (s *containerVolumeSource) ListArchives() ([]uback.Snapshot, error) {
+	return nil, nil
+}
+
+// Part of uback.Source interface
+func (s *containerVolumeSource) ListBookmarks() ([]uback.Snapshot, error) {
+	return nil, nil
+}
+
+// Part of uback.Source interface
+func (s *containerVolumeSource) RemoveArchive(snapshot uback.Snapshot) error {
+	panic("should never happen")
+}
+
+// Part of uback.Source interface
+func (s *containerVolumeSource) RemoveBookmark(snapshot uback.Snapshot) error {
+	panic("should never happen")
+}
+
+// Run a command in the container
+func (s *containerVolumeSource) exec(command []string) error {
+	return uback.RunCommand(containerVolumeLog, uback.BuildCommand(s.command, append([]string{"exec", s.container}, command...)...))
+}
+
+// Build the command writing the content of a volume as a tar archive on its standard output
+func (s *containerVolumeSource) exportCommand(volume string) *exec.Cmd {
+	if s.usePodman {
+		return uback.BuildCommand(s.command, "volume", "export", volume)
+	}
+	return uback.BuildCommand(s.command, "run", "--rm", "-v", volume+":/volume:ro", s.helperImage, "tar", "-C", "/volume", "-cf", "-", ".")
+}
+
+// Build the command reading a tar archive on its standard input and extracting it into a volume
+func (s *containerVolumeSource) importCommand(volume string) *exec.Cmd {
+	if s.usePodman {
+		return uback.BuildCommand(s.command, "volume", "import", volume, "-")
+	}
+	return uback.BuildCommand(s.command, "run", "--rm", "-i", "-v", volume+":/volume", s.helperImage, "tar", "-C", "/volume", "-xf", "-")
+}
+
+// Write the content of all volumes to tw, each one in a directory named after the volume
+func (s *containerVolumeSource) exportVolumes(tw *tar.Writer) error {
+	for _, volume := range s.volumes {
+		cmd := s.exportCommand(volume)
+		cmd.Stdout = nil
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+
+		err = uback.StartCommand(containerVolumeLog, cmd)
+		if err != nil {
+			return err
+		}
+
+		err = copyTarWithPrefix(tw, stdout, volume)
+		if err != nil {
+			cmd.Process.Kill() //nolint:errcheck
+			cmd.Wait()         //nolint:errcheck
+			return err
+		}
+
+		err = cmd.Wait()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// Copy the entries of a tar archive to tw, moving them into the prefix directory
+func copyTarWithPrefix(tw *tar.Writer, r io.Reader, prefix string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = tarPrefixedName(prefix, hdr.Name, hdr.Typeflag == tar.TypeDir)
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = tarPrefixedName(prefix, hdr.Linkname, false)
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tw, tr)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Forward tar padding
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+func tarPrefixedName(prefix, name string, isDir bool) string {
+	p := path.Join(prefix, tarEntryPath(name))
+	if isDir {
+		p += "/"
+	}
+	return p
+}
+
+// Part of uback.Source interface
+func (s *containerVolumeSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io.ReadCloser, error) {
+	snapshot := time.Now().UTC().Format(uback.SnapshotTimeFormat)
+	backup := uback.Backup{Snapshot: uback.Snapshot(snapshot), BaseSnapshot: nil}
+	containerVolumeLog.Printf("creating backup: %s", backup.Filename())
+
+	if len(s.execBefore) > 0 {
+		err := s.exec(s.execBefore)
+		if err != nil {
+			return uback.Backup{}, nil, err
+		}
+	}
+
+	if s.pause {
+		err := uback.RunCommand(containerVolumeLog, uback.BuildCommand(s.command, "pause", s.container))
+		if err != nil {
+			if len(s.execAfter) > 0 {
+				err = errors.Join(err, s.exec(s.execAfter))
+			}
+			return uback.Backup{}, nil, err
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.exportVolumes(tar.NewWriter(pw)))
+	}()
+
+	return backup, uback.WrapCleanup(pr, func() error {
+		var errs []error
+		if s.pause {
+			errs = append(errs, uback.RunCommand(containerVolumeLog, uback.BuildCommand(s.command, "unpause", s.container)))
+		}
+		if len(s.execAfter) > 0 {
+			errs = append(errs, s.exec(s.execAfter))
+		}
+		return errors.Join(errs...)
+	}), nil
+}
+
+// Create a volume if it does not exist yet
+func (s *containerVolumeSource) ensureVolume(volume string) error {
+	cmd := uback.BuildCommand(s.command, "volume", "inspect", volume)
+	cmd.Stderr = nil
+	if cmd.Run() == nil {
+		return nil
+	}
+
+	return uback.RunCommand(containerVolumeLog, uback.BuildCommand(s.command, "volume", "create", volume))
+}
+
+// A volume being imported
+type volumeImport struct {
+	cmd *exec.Cmd
+	tw  *tar.Writer
+	w   io.WriteCloser
+}
+
+func (vi *volumeImport) close() error {
+	err := vi.tw.Close()
+	if err != nil {
+		vi.w.Close()
+		vi.cmd.Wait() //nolint:errcheck
+		return err
+	}
+
+	err = vi.w.Close()
+	if err != nil {
+		vi.cmd.Wait() //nolint:errcheck
+		return err
+	}
+
+	return vi.cmd.Wait()
+}
+
+// Import the content of a backup into volumes
+func (s *containerVolumeSource) importVolumes(data io.Reader) error {
+	var current *volumeImport
+	currentVolume := ""
+	seen := make(map[string]bool)
+
+	tr := tar.NewReader(data)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if current != nil {
+				current.close() //nolint:errcheck
+			}
+			return err
+		}
+
+		name := tarEntryPath(hdr.Name)
+		parts := strings.SplitN(name, "/", 2)
+		if name == "" {
+			continue
+		}
+
+		if parts[0] != currentVolume {
+			if current != nil {
+				err = current.close()
+				if err != nil {
+					return err
+				}
+				current = nil
+			}
+
+			currentVolume = parts[0]
+			targetVolume := currentVolume
+			if s.volumeName != "" {
+				if len(seen) > 0 {
+					return ErrContainerVolumeName
+				}
+				targetVolume = s.volumeName
+			}
+			seen[currentVolume] = true
+
+			err = s.ensureVolume(targetVolume)
+			if err != nil {
+				return err
+			}
+
+			cmd := s.importCommand(targetVolume)
+			w, err := cmd.StdinPipe()
+			if err != nil {
+				return err
+			}
+
+			err = uback.StartCommand(containerVolumeLog, cmd)
+			if err != nil {
+				return err
+			}
+
+			current = &volumeImport{cmd: cmd, tw: tar.NewWriter(w), w: w}
+		}
+
+		// Strip the volume directory
+		relName := "."
+		if len(parts) > 1 {
+			relName = "./" + parts[1]
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			relName += "/"
+		}
+		hdr.Name = relName
+		if hdr.Typeflag == tar.TypeLink {
+			linkParts := strings.SplitN(tarEntryPath(hdr.Linkname), "/", 2)
+			if len(linkParts) == 2 {
+				hdr.Linkname = "./" + linkParts[1]
+			}
+		}
+
+		err = current.tw.WriteHeader(hdr)
+		if err == nil {
+			_, err = io.Copy(current.tw, tr)
+		}
+		if err != nil {
+			current.close() //nolint:errcheck
+			return err
+		}
+	}
+
+	if current != nil {
+		return current.close()
+	}
+
+	return nil
+}
+
+// Part of uback.Source interface
+func (s *containerVolumeSource) RestoreBackup(targetDir string, backup uback.Backup, data io.Reader) error {
+	if s.restoreToVolume {
+		return s.importVolumes(data)
+	}
+
+	return (&tarSource{}).restore(targetDir, backup, data, nil)
+}
+
+// Part of uback.BrowsableSource interface
+func (s *containerVolumeSource) RestorePartialBackup(targetDir string, backup uback.Backup, data io.Reader, patterns []string) error {
+	if s.restoreToVolume {
+		return fmt.Errorf("container-volume source: partial restoration into a volume is not supported")
+	}
+
+	return (&tarSource{}).restore(targetDir, backup, data, patterns)
+}
+
+// Part of uback.BrowsableSource interface
+func (s *containerVolumeSource) ListBackupEntries(entries map[string]uback.BackupEntry, backup uback.Backup, data io.Reader) error {
+	return listTar(entries, data)
+}
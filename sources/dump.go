@@ -0,0 +1,303 @@
+package sources
+
+import (
+	uback "github.com/sloonz/uback/lib"
+
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrDumpEngine   = errors.New("dump source: missing or invalid engine")
+	ErrDumpDatabase = errors.New("dump source: missing database")
+	ErrDumpCommand  = errors.New("dump source: missing or invalid command")
+	ErrDumpNoTar    = errors.New("dump source: multiple databases require the Tar option (or one source per database)")
+	dumpLog         = logrus.WithFields(logrus.Fields{
+		"source": "dump",
+	})
+)
+
+// A database engine supported by the dump source
+type dumpEngine struct {
+	command   []string
+	extension string
+
+	// Build the command dumping the database to its standard output. If nil, dumpFile is used instead
+	dumpArgs func(db string) []string
+
+	// Build the command dumping the database to a file
+	dumpFileArgs func(db, dst string) []string
+
+	// Name of the dump of the database in a tar archive
+	filename func(db string) string
+}
+
+var dumpEngines = map[string]dumpEngine{
+	"postgres": {
+		command:   []string{"pg_dump"},
+		extension: ".dump",
+		dumpArgs: func(db string) []string {
+			return []string{"--format=custom", db}
+		},
+		filename: func(db string) string {
+			return db + ".dump"
+		},
+	},
+	"mysql": {
+		command:   []string{"mysqldump"},
+		extension: ".sql",
+		dumpArgs: func(db string) []string {
+			return []string{"--single-transaction", "--databases", db}
+		},
+		filename: func(db string) string {
+			return db + ".sql"
+		},
+	},
+	"sqlite": {
+		command:   []string{"sqlite3"},
+		extension: ".sqlite",
+		dumpFileArgs: func(db, dst string) []string {
+			return []string{db, fmt.Sprintf(".backup '%s'", strings.ReplaceAll(dst, "'", "''"))}
+		},
+		filename: func(db string) string {
+			return path.Base(db)
+		},
+	},
+}
+
+type dumpSource struct {
+	options   *uback.Options
+	engine    dumpEngine
+	command   []string
+	databases []string
+	tar       bool
+}
+
+func newDumpSource(options *uback.Options) (uback.Source, string, error) {
+	engineName := options.String["Engine"]
+	engine, ok := dumpEngines[engineName]
+	if !ok {
+		return nil, "", ErrDumpEngine
+	}
+
+	databases := options.StrSlice["Database"]
+	if len(databases) == 0 {
+		return nil, "", ErrDumpDatabase
+	}
+
+	useTar, err := options.GetBoolean("Tar", false)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(databases) > 1 && !useTar {
+		return nil, "", ErrDumpNoTar
+	}
+
+	// Dumps of different databases must not overwrite each other in the archive
+	filenames := make(map[string]string)
+	for _, db := range databases {
+		filename := engine.filename(db)
+		if other, ok := filenames[filename]; ok {
+			return nil, "", fmt.Errorf("dump source: databases %s and %s would both be dumped to %s", other, db, filename)
+		}
+		filenames[filename] = db
+	}
+
+	command := options.GetCommand("Command", engine.command)
+	if len(command) == 0 {
+		return nil, "", ErrDumpCommand
+	}
+
+	typ := "dump:" + engineName
+	if useTar {
+		typ += ":tar"
+	}
+
+	return &dumpSource{options: options, engine: engine, command: command, databases: databases, tar: useTar}, typ, nil
+}
+
+func newDumpSourceForRestoration(typ string) (uback.Source, error) {
+	parts := strings.Split(typ, ":")
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "tar") {
+		return nil, fmt.Errorf("invalid source type %v", typ)
+	}
+
+	engine, ok := dumpEngines[parts[1]]
+	if !ok {
+		return nil, ErrDumpEngine
+	}
+
+	return &dumpSource{engine: engine, tar: len(parts) == 3}, nil
+}
+
+// Part of uback.Source interface
+func (s *dumpSource) ListArchives() ([]uback.Snapshot, error) {
+	return nil, nil
+}
+
+// Part of uback.Source interface
+func (s *dumpSource) ListBookmarks() ([]uback.Snapshot, error) {
+	return nil, nil
+}
+
+// Part of uback.Source interface
+func (s *dumpSource) RemoveArchive(snapshot uback.Snapshot) error {
+	panic("should never happen")
+}
+
+// Part of uback.Source interface
+func (s *dumpSource) RemoveBookmark(snapshot uback.Snapshot) error {
+	panic("should never happen")
+}
+
+// Write the dump of a database to w
+func (s *dumpSource) dump(db string, w io.Writer) error {
+	if s.engine.dumpArgs != nil {
+		cmd := uback.BuildCommand(s.command, s.engine.dumpArgs(db)...)
+		cmd.Stdout = w
+		return uback.RunCommand(dumpLog, cmd)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "uback-dump-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dst := path.Join(tmpDir, "dump")
+	err = uback.RunCommand(dumpLog, uback.BuildCommand(s.command, s.engine.dumpFileArgs(db, dst)...))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Write a tar archive containing the dumps of all databases to w. Since tar needs the size of
+// each entry beforehand, dumps go through a temporary file
+func (s *dumpSource) dumpTar(w io.Writer) error {
+	tmpFile, err := os.CreateTemp("", "uback-dump-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	tw := tar.NewWriter(w)
+	for _, db := range s.databases {
+		err = tmpFile.Truncate(0)
+		if err != nil {
+			return err
+		}
+
+		_, err = tmpFile.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		err = s.dump(db, tmpFile)
+		if err != nil {
+			return err
+		}
+
+		size, err := tmpFile.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+
+		_, err = tmpFile.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     s.engine.filename(db),
+			Size:     size,
+			Mode:     0600,
+			ModTime:  time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = io.CopyN(tw, tmpFile, size)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// Part of uback.Source interface
+func (s *dumpSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io.ReadCloser, error) {
+	snapshot := time.Now().UTC().Format(uback.SnapshotTimeFormat)
+	backup := uback.Backup{Snapshot: uback.Snapshot(snapshot), BaseSnapshot: nil}
+	dumpLog.Printf("creating backup: %s", backup.Filename())
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if s.tar {
+			pw.CloseWithError(s.dumpTar(pw))
+		} else {
+			pw.CloseWithError(s.dump(s.databases[0], pw))
+		}
+	}()
+
+	// When closed early, wait for the dump command to exit and the temporary files to be removed
+	return backup, uback.WrapCleanup(pr, func() error {
+		<-done
+		return nil
+	}), nil
+}
+
+// Part of uback.Source interface
+func (s *dumpSource) RestoreBackup(targetDir string, backup uback.Backup, data io.Reader) error {
+	if !s.tar {
+		f, err := os.OpenFile(path.Join(targetDir, backup.Snapshot.Name()+s.engine.extension), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(f, data)
+		if err != nil {
+			return err
+		}
+
+		return f.Close()
+	}
+
+	restoreDir := path.Join(targetDir, backup.Snapshot.Name())
+	err := os.RemoveAll(restoreDir)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(restoreDir, 0777)
+	if err != nil {
+		return err
+	}
+
+	dumpLog.Printf("extracting %s onto %s", backup.Filename(), restoreDir)
+	return extractTar(restoreDir, data, nil)
+}
@@ -0,0 +1,65 @@
+package sources
+
+import (
+	"io/fs"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sum the size of the regular files under root changed after since (or all files if since is the
+// zero time). skip is called with the path of each entry relative to root, and can exclude it (and
+// its contents, for a directory).
+func estimateTreeSize(log *logrus.Entry, root string, since time.Time, skip func(name string, p string, d fs.DirEntry) bool) (int64, error) {
+	var size int64
+	root = filepath.Clean(root)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Warnf("%v", err)
+			if d != nil && d.IsDir() && p != root {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if p != root && skip != nil {
+			name, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			if skip(name, p, d) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			log.Warnf("%v", err)
+			return nil
+		}
+
+		if !since.IsZero() {
+			changed := fi.ModTime().After(since)
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				changed = changed || time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec)).After(since)
+			}
+			if !changed {
+				return nil
+			}
+		}
+
+		size += fi.Size()
+		return nil
+	})
+
+	return size, err
+}
@@ -0,0 +1,218 @@
+package sources
+
+import (
+	uback "github.com/sloonz/uback/lib"
+
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrLvmVolume = errors.New("lvm source: missing or invalid volume")
+	ErrLvmMode   = errors.New("lvm source: invalid mode")
+	lvmLog       = logrus.WithFields(logrus.Fields{
+		"source": "lvm",
+	})
+)
+
+type lvmSource struct {
+	options         *uback.Options
+	volumeGroup     string
+	logicalVolume   string
+	raw             bool
+	mountPath       string
+	devicesPath     string
+	snapshotCommand []string
+	mountCommand    []string
+	unmountCommand  []string
+	removeCommand   []string
+	tar             *tarSource
+}
+
+func newLvmSource(options *uback.Options) (uback.Source, string, error) {
+	vgLv := strings.Split(options.String["Volume"], "/")
+	if len(vgLv) != 2 || vgLv[0] == "" || vgLv[1] == "" {
+		return nil, "", ErrLvmVolume
+	}
+
+	var raw bool
+	switch options.GetString("Mode", "tar") {
+	case "tar":
+		raw = false
+	case "raw":
+		raw = true
+	default:
+		return nil, "", ErrLvmMode
+	}
+
+	s := &lvmSource{
+		options:         options,
+		volumeGroup:     vgLv[0],
+		logicalVolume:   vgLv[1],
+		raw:             raw,
+		mountPath:       options.String["MountPath"],
+		devicesPath:     options.GetString("DevicesPath", "/dev"),
+		snapshotCommand: options.GetCommand("SnapshotCommand", []string{"lvcreate", "--snapshot", "--setactivationskip", "n"}),
+		mountCommand:    options.GetCommand("MountCommand", []string{"mount", "-o", "ro"}),
+		unmountCommand:  options.GetCommand("UnmountCommand", []string{"umount"}),
+		removeCommand:   options.GetCommand("RemoveCommand", []string{"lvremove", "-y"}),
+	}
+
+	if raw {
+		return s, "lvm", nil
+	}
+
+	snapshotsPath := options.String["SnapshotsPath"]
+	if snapshotsPath == "" {
+		lvmLog.Warnf("SnapshotsPath option missing, incremental backups will be impossible")
+	} else {
+		err := os.MkdirAll(snapshotsPath, 0777)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	filters, err := newTarFilters(options)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The snapshot device number changes at each mount, so do not let tar consider that
+	// everything has been modified
+	s.tar = &tarSource{
+		options:       options,
+		snapshotsPath: snapshotsPath,
+		command:       options.GetCommand("Command", []string{"tar", "--no-check-device"}),
+		filters:       filters,
+	}
+
+	// Backups are plain tar archives, and are restored as such
+	return s, "tar", nil
+}
+
+func newLvmSourceForRestoration() (uback.Source, error) {
+	return &lvmSource{raw: true}, nil
+}
+
+// Part of uback.Source interface
+func (s *lvmSource) ListArchives() ([]uback.Snapshot, error) {
+	return nil, nil
+}
+
+// Part of uback.Source interface
+func (s *lvmSource) ListBookmarks() ([]uback.Snapshot, error) {
+	if s.raw {
+		return nil, nil
+	}
+	return s.tar.ListBookmarks()
+}
+
+// Part of uback.Source interface
+func (s *lvmSource) RemoveArchive(snapshot uback.Snapshot) error {
+	panic("should never happen")
+}
+
+// Part of uback.Source interface
+func (s *lvmSource) RemoveBookmark(snapshot uback.Snapshot) error {
+	if s.raw {
+		panic("should never happen")
+	}
+	return s.tar.RemoveBookmark(snapshot)
+}
+
+// Part of uback.Source interface
+func (s *lvmSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io.ReadCloser, error) {
+	snapshot := time.Now().UTC().Format(uback.SnapshotTimeFormat)
+	lvSnapshot := fmt.Sprintf("%s-uback-%s", s.logicalVolume, snapshot)
+	device := path.Join(s.devicesPath, s.volumeGroup, lvSnapshot)
+
+	err := uback.RunCommand(lvmLog, uback.BuildCommand(s.snapshotCommand, "--name", lvSnapshot, fmt.Sprintf("%s/%s", s.volumeGroup, s.logicalVolume)))
+	if err != nil {
+		return uback.Backup{}, nil, err
+	}
+
+	removeSnapshot := func() error {
+		return uback.RunCommand(lvmLog, uback.BuildCommand(s.removeCommand, fmt.Sprintf("%s/%s", s.volumeGroup, lvSnapshot)))
+	}
+
+	if s.raw {
+		backup := uback.Backup{Snapshot: uback.Snapshot(snapshot), BaseSnapshot: nil}
+		lvmLog.Printf("creating backup: %s", backup.Filename())
+
+		f, err := os.Open(device)
+		if err != nil {
+			_ = removeSnapshot()
+			return uback.Backup{}, nil, err
+		}
+
+		return backup, uback.WrapCleanup(f, removeSnapshot), nil
+	}
+
+	mountPath := s.mountPath
+	if mountPath == "" {
+		mountPath, err = os.MkdirTemp("", "uback-lvm-")
+	} else {
+		err = os.MkdirAll(mountPath, 0700)
+	}
+	if err != nil {
+		_ = removeSnapshot()
+		return uback.Backup{}, nil, err
+	}
+
+	cleanup := func() error {
+		err := uback.RunCommand(lvmLog, uback.BuildCommand(s.unmountCommand, mountPath))
+		if err != nil {
+			// Do not remove the snapshot or the mount point while it is still mounted
+			return err
+		}
+
+		if s.mountPath == "" {
+			if err := os.Remove(mountPath); err != nil {
+				lvmLog.Warnf("cannot remove mount point: %v", err)
+			}
+		}
+
+		return removeSnapshot()
+	}
+
+	err = uback.RunCommand(lvmLog, uback.BuildCommand(s.mountCommand, device, mountPath))
+	if err != nil {
+		if s.mountPath == "" {
+			os.Remove(mountPath)
+		}
+		_ = removeSnapshot()
+		return uback.Backup{}, nil, err
+	}
+
+	s.tar.basePath = mountPath
+	backup, data, err := s.tar.CreateBackup(baseSnapshot)
+	if err != nil {
+		_ = cleanup()
+		return uback.Backup{}, nil, err
+	}
+
+	return backup, uback.WrapCleanup(data, cleanup), nil
+}
+
+// Part of uback.Source interface
+func (s *lvmSource) RestoreBackup(targetDir string, backup uback.Backup, data io.Reader) error {
+	f, err := os.OpenFile(path.Join(targetDir, backup.Snapshot.Name()+".img"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, data)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
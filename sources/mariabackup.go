@@ -54,6 +54,7 @@ type mariaBackupSource struct {
 	authFileData      string
 	versionCheck      bool
 	usePodman         bool
+	dataDir           string
 }
 
 func newMariaBackupSource(options *uback.Options) (uback.Source, error) {
@@ -101,7 +102,8 @@ func newMariaBackupSource(options *uback.Options) (uback.Source, error) {
 		command:           command,
 		mdbVersionCommand: mdbVersionCommand,
 		versionCheck:      versionCheck,
-		authFileData:      authFileData}, nil
+		authFileData:      authFileData,
+		dataDir:           options.String["DataDir"]}, nil
 }
 
 func newMariaBackupSourceForRestoration(options *uback.Options) (uback.Source, error) {
@@ -271,6 +273,16 @@ func (s *mariaBackupSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Ba
 	})
 }
 
+// Part of uback.SizeEstimator interface. This is the size of the data directory of the server, which
+// is an upper bound for incremental backups.
+func (s *mariaBackupSource) EstimateBackupSize(baseSnapshot *uback.Snapshot) (int64, error) {
+	if s.dataDir == "" {
+		return 0, fmt.Errorf("mariabackup source: DataDir option required for size estimation")
+	}
+
+	return estimateTreeSize(mariaBackupLog, s.dataDir, time.Time{}, nil)
+}
+
 // Part of uback.Source interface
 func (s *mariaBackupSource) RestoreBackup(targetDir string, backup uback.Backup, data io.Reader) error {
 	err := os.RemoveAll(path.Join(targetDir, backup.Snapshot.Name()))
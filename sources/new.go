@@ -20,6 +20,16 @@ func New(options *uback.Options) (src uback.Source, typ string, err error) {
 		src, err = newTarSource(options)
 	case "mariabackup":
 		src, err = newMariaBackupSource(options)
+	case "binlog":
+		src, err = newBinlogSource(options)
+	case "postgres":
+		src, err = newPostgresSource(options)
+	case "lvm":
+		src, typ, err = newLvmSource(options)
+	case "container-volume":
+		src, err = newContainerVolumeSource(options)
+	case "dump":
+		src, typ, err = newDumpSource(options)
 	case "command":
 		src, typ, err = newCommandSource(options)
 	case "proxy":
@@ -41,6 +51,14 @@ func NewForRestoration(options *uback.Options, typ string) (uback.Source, error)
 		return newTarSourceForRestoration()
 	case "mariabackup":
 		return newMariaBackupSourceForRestoration(options)
+	case "lvm":
+		return newLvmSourceForRestoration()
+	case "container-volume":
+		return newContainerVolumeSourceForRestoration(options)
+	case "binlog":
+		return newBinlogSourceForRestoration(options)
+	case "postgres":
+		return newPostgresSourceForRestoration(options)
 	case "proxy":
 		return nil, ErrProxyNoRestoration
 	default:
@@ -51,6 +69,9 @@ func NewForRestoration(options *uback.Options, typ string) (uback.Source, error)
 			}
 			return newCommandSourceForRestoration(command, options)
 		}
+		if strings.HasPrefix(typ, "dump:") {
+			return newDumpSourceForRestoration(typ)
+		}
 		return nil, fmt.Errorf("invalid source type %v", typ)
 	}
 }
@@ -0,0 +1,287 @@
+package sources
+
+import (
+	uback "github.com/sloonz/uback/lib"
+
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrPostgresCommand        = errors.New("postgres source: missing or invalid pg_basebackup command")
+	ErrPostgresCombineCommand = errors.New("postgres source: missing or invalid pg_combinebackup command")
+	ErrPostgresNoManifest     = errors.New("postgres source: backup manifest not found in pg_basebackup output")
+	postgresLog               = logrus.WithFields(logrus.Fields{
+		"source": "postgres",
+	})
+)
+
+type postgresSource struct {
+	options        *uback.Options
+	snapshotsPath  string
+	command        []string
+	combineCommand []string
+}
+
+func newPostgresSource(options *uback.Options) (uback.Source, error) {
+	snapshotsPath := options.String["SnapshotsPath"]
+	if snapshotsPath == "" {
+		postgresLog.Warnf("SnapshotsPath option missing, incremental backups will be impossible")
+	} else {
+		err := os.MkdirAll(snapshotsPath, 0777)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	command := options.GetCommand("Command", []string{"pg_basebackup"})
+	if len(command) == 0 {
+		return nil, ErrPostgresCommand
+	}
+
+	return &postgresSource{options: options, snapshotsPath: snapshotsPath, command: command}, nil
+}
+
+func newPostgresSourceForRestoration(options *uback.Options) (uback.Source, error) {
+	combineCommand := options.GetCommand("CombineCommand", []string{"pg_combinebackup"})
+	if len(combineCommand) == 0 {
+		return nil, ErrPostgresCombineCommand
+	}
+
+	return &postgresSource{combineCommand: combineCommand}, nil
+}
+
+// Incremental backups require pg_basebackup 17 or later
+func (s *postgresSource) supportsIncremental() (bool, error) {
+	cmd := uback.BuildCommand(s.command, "--version")
+	cmd.Stdout = nil
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("cannot get pg_basebackup version: %v", err)
+	}
+
+	m := regexp.MustCompile(`\s(\d+)(\.\d+)*`).FindSubmatch(out)
+	if m == nil {
+		return false, fmt.Errorf("cannot parse pg_basebackup version: %s", strings.TrimSpace(string(out)))
+	}
+
+	major, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return false, err
+	}
+
+	return major >= 17, nil
+}
+
+// Part of uback.Source interface
+func (s *postgresSource) ListArchives() ([]uback.Snapshot, error) {
+	return nil, nil
+}
+
+// Part of uback.Source interface
+func (s *postgresSource) ListBookmarks() ([]uback.Snapshot, error) {
+	if s.snapshotsPath == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(s.snapshotsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []uback.Snapshot
+
+	re := regexp.MustCompile(fmt.Sprintf("^%s$", uback.SnapshotRe))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || strings.HasPrefix(entry.Name(), "_") || entry.IsDir() {
+			continue
+		}
+
+		if !re.MatchString(entry.Name()) {
+			postgresLog.WithFields(logrus.Fields{"file": entry.Name()}).Warnf("invalid snapshot name")
+			continue
+		}
+
+		snapshots = append(snapshots, uback.Snapshot(entry.Name()))
+	}
+
+	return snapshots, nil
+}
+
+// Part of uback.Source interface
+func (s *postgresSource) RemoveArchive(snapshot uback.Snapshot) error {
+	panic("should never happen")
+}
+
+// Part of uback.Source interface
+func (s *postgresSource) RemoveBookmark(snapshot uback.Snapshot) error {
+	if s.snapshotsPath == "" {
+		return nil
+	}
+	return os.Remove(path.Join(s.snapshotsPath, string(snapshot)))
+}
+
+// Part of uback.Source interface
+func (s *postgresSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io.ReadCloser, error) {
+	snapshot := time.Now().UTC().Format(uback.SnapshotTimeFormat)
+	tmpSnapshotPath := path.Join(s.snapshotsPath, fmt.Sprintf("_tmp-%s", snapshot))
+	finalSnapshotPath := path.Join(s.snapshotsPath, snapshot)
+
+	if s.snapshotsPath == "" {
+		baseSnapshot = nil
+	}
+
+	if baseSnapshot != nil {
+		ok, err := s.supportsIncremental()
+		if err != nil {
+			return uback.Backup{}, nil, err
+		}
+		if !ok {
+			postgresLog.Warnf("incremental backups require PostgreSQL 17 or later, forcing full backup")
+			baseSnapshot = nil
+		}
+	}
+
+	// With --pgdata=-, the WAL needed to restore the backup and the backup manifest are
+	// included in the tar stream
+	args := []string{"--pgdata=-", "--format=tar", "--wal-method=fetch"}
+	if baseSnapshot != nil {
+		args = append(args, fmt.Sprintf("--incremental=%s", path.Join(s.snapshotsPath, baseSnapshot.Name())))
+	}
+
+	backup := uback.Backup{Snapshot: uback.Snapshot(snapshot), BaseSnapshot: baseSnapshot}
+	postgresLog.Printf("creating backup: %s", backup.Filename())
+
+	backup, data, err := uback.WrapSourceCommand(backup, uback.BuildCommand(s.command, args...), nil)
+	if err != nil || s.snapshotsPath == "" {
+		return backup, data, err
+	}
+
+	// Extract the manifest from the stream on the fly ; it will be the bookmark for the next
+	// incremental backup
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		found := false
+		err := func() error {
+			tee := io.TeeReader(data, pw)
+			tr := tar.NewReader(tee)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+
+				if path.Clean(hdr.Name) == "backup_manifest" {
+					f, err := os.Create(tmpSnapshotPath)
+					if err != nil {
+						return err
+					}
+					_, err = io.Copy(f, tr)
+					if err != nil {
+						f.Close()
+						return err
+					}
+					err = f.Close()
+					if err != nil {
+						return err
+					}
+					found = true
+				}
+			}
+
+			// Forward tar padding
+			_, err := io.Copy(io.Discard, tee)
+			if err != nil {
+				return err
+			}
+
+			return data.Close()
+		}()
+
+		if err == nil && !found {
+			err = ErrPostgresNoManifest
+		}
+		if err == nil {
+			err = os.Rename(tmpSnapshotPath, finalSnapshotPath)
+		}
+		if err != nil {
+			data.Close()
+			os.Remove(tmpSnapshotPath)
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// When closed early, kill pg_basebackup and wait for the removal of the temporary manifest
+	return backup, uback.WrapCleanup(pr, func() error {
+		data.Close()
+		<-done
+		return nil
+	}), nil
+}
+
+func (s *postgresSource) extract(targetDir string, data io.Reader) error {
+	err := os.MkdirAll(targetDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("tar", "-x", "-C", targetDir)
+	cmd.Stdin = data
+	return uback.RunCommand(postgresLog, cmd)
+}
+
+// Part of uback.Source interface
+func (s *postgresSource) RestoreBackup(targetDir string, backup uback.Backup, data io.Reader) error {
+	restoreDir := path.Join(targetDir, backup.Snapshot.Name())
+	err := os.RemoveAll(restoreDir)
+	if err != nil {
+		return err
+	}
+
+	if backup.BaseSnapshot == nil {
+		return s.extract(restoreDir, data)
+	}
+
+	incrementalDir := path.Join(targetDir, fmt.Sprintf("_incremental-%s", backup.Snapshot.Name()))
+	err = os.RemoveAll(incrementalDir)
+	if err != nil {
+		return err
+	}
+
+	err = s.extract(incrementalDir, data)
+	if err != nil {
+		return err
+	}
+
+	// The base has already been restored (and combined with its own base if needed), so this
+	// is always a full backup and combining it with the incremental gives another full backup
+	baseDir := path.Join(targetDir, backup.BaseSnapshot.Name())
+	cmd := uback.BuildCommand(s.combineCommand, fmt.Sprintf("--output=%s", restoreDir), baseDir, incrementalDir)
+	err = uback.RunCommand(postgresLog, cmd)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(incrementalDir)
+	if err != nil {
+		return err
+	}
+
+	return os.RemoveAll(baseDir)
+}
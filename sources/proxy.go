@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -32,11 +33,20 @@ type RemoveSnapshotArgs struct {
 type CreateBackupArgs struct {
 	uback.Options
 	*uback.Snapshot
+
+	// If true, the proxy must compress and encrypt the backup data itself
+	Seal bool
+
+	// Recipients (in age format) used to encrypt the backup when Seal is true. If empty, the
+	// backup is only compressed
+	Recipients string
 }
 
 type proxySource struct {
-	options *uback.Options
-	command []string
+	options          *uback.Options
+	command          []string
+	remoteEncryption bool
+	recipients       string
 }
 
 func newProxySource(options *uback.Options) (uback.Source, string, error) {
@@ -57,7 +67,34 @@ func newProxySource(options *uback.Options) (uback.Source, string, error) {
 		}
 	}
 
-	return &proxySource{options: options, command: command}, typ, nil
+	remoteEncryption, err := options.GetBoolean("RemoteEncryption", false)
+	if err != nil {
+		return nil, "", err
+	}
+
+	recipients := ""
+	if remoteEncryption && options.String["NoEncryption"] == "" {
+		if _, err := uback.LoadRecipients(options.String["KeyFile"], options.String["Key"]); err != nil {
+			return nil, "", err
+		}
+
+		// Send the key content rather than the key file, which may not exist on the proxy side
+		recipients = options.String["Key"]
+		if options.String["KeyFile"] != "" {
+			data, err := os.ReadFile(options.String["KeyFile"])
+			if err != nil {
+				return nil, "", err
+			}
+			recipients = string(data)
+		}
+	}
+
+	return &proxySource{options: options, command: command, remoteEncryption: remoteEncryption, recipients: recipients}, typ, nil
+}
+
+// Part of uback.SealedSource interface
+func (s *proxySource) IsSealed() bool {
+	return s.remoteEncryption
 }
 
 func (s *proxySource) listSnapshots(kind string) ([]uback.Snapshot, error) {
@@ -120,7 +157,7 @@ func (s *proxySource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup,
 	}
 
 	var backup uback.Backup
-	err = rpcClient.Call("Source.CreateBackup", &CreateBackupArgs{Options: uback.ProxiedOptions(s.options), Snapshot: baseSnapshot}, &backup)
+	err = rpcClient.Call("Source.CreateBackup", &CreateBackupArgs{Options: uback.ProxiedOptions(s.options), Snapshot: baseSnapshot, Seal: s.remoteEncryption, Recipients: s.recipients}, &backup)
 	if err != nil {
 		return uback.Backup{}, nil, err
 	}
@@ -0,0 +1,59 @@
+#!/bin/sh
+
+# Usage: binlog-replay.sh [datadir]
+# Print on stdout the SQL statements of the archived binary logs, starting from the
+# position recorded in the (prepared) mariabackup datadir if given
+#
+# When STOP_GTID is set, the start position is the GTID recorded in the datadir, since
+# mariadb-binlog cannot mix a GTID and a file offset
+
+set -e
+
+binlogdir=$(realpath "$(dirname "$0")")
+. "$binlogdir/binlog-replay.conf"
+
+# Numeric extension of a binary log, which may grow a digit (mysql-bin.999999 is followed by
+# mysql-bin.1000000), so names cannot be compared as strings
+binlognum() {
+	expr "${1##*.}" + 0
+}
+
+startfile=
+startpos=
+if [ -n "$1" ] ; then
+	for info in "$1/mariadb_backup_binlog_info" "$1/xtrabackup_binlog_info" ; do
+		if [ -f "$info" ] ; then
+			startfile=$(cut -f1 "$info")
+			if [ -n "$STOP_GTID" ] ; then
+				startpos=$(cut -sf3 "$info")
+			else
+				startpos=$(cut -f2 "$info")
+			fi
+			break
+		fi
+	done
+	if [ -z "$startfile" ] || [ -z "$startpos" ] ; then
+		echo "cannot find binary log position in $1" >&2
+		exit 1
+	fi
+fi
+
+set --
+for f in $(ls "$binlogdir" | grep -v '^binlog-replay\.' | while read -r f ; do echo "$(binlognum "$f") $f" ; done | sort -n | cut -d' ' -f2) ; do
+	if [ -z "$startfile" ] || [ "$(binlognum "$f")" -ge "$(binlognum "$startfile")" ] ; then
+		set -- "$@" "$binlogdir/$f"
+	fi
+done
+
+if [ $# -eq 0 ] ; then
+	echo "no binary log to replay" >&2
+	exit 1
+fi
+
+if [ -n "$startfile" ] && [ "$(basename "$1")" != "$startfile" ] ; then
+	echo "binary log $startfile is missing from the archive" >&2
+	exit 1
+fi
+
+set -x
+mariadb-binlog ${startpos:+--start-position="$startpos"} ${STOP_DATETIME:+--stop-datetime="$STOP_DATETIME"} ${STOP_POSITION:+--stop-position="$STOP_POSITION"} ${STOP_GTID:+--stop-position="$STOP_GTID"} "$@"
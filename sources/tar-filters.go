@@ -0,0 +1,207 @@
+package sources
+
+import (
+	uback "github.com/sloonz/uback/lib"
+
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Signature of a valid CACHEDIR.TAG file, see https://bford.info/cachedir/
+const cacheDirTagSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
+// Filters restricting which files are archived by the tar source
+type tarFilters struct {
+	include          []string
+	exclude          []string
+	excludeFile      string
+	excludeCaches    bool
+	oneFileSystem    bool
+	excludeIfPresent string
+}
+
+func newTarFilters(options *uback.Options) (tarFilters, error) {
+	excludeCaches, err := options.GetBoolean("ExcludeCaches", false)
+	if err != nil {
+		return tarFilters{}, err
+	}
+
+	oneFileSystem, err := options.GetBoolean("OneFileSystem", false)
+	if err != nil {
+		return tarFilters{}, err
+	}
+
+	var include []string
+	for _, inc := range options.StrSlice["Include"] {
+		clean := path.Clean("/" + inc)
+		if clean == "/" {
+			return tarFilters{}, fmt.Errorf("tar source: invalid include path: %s", inc)
+		}
+		include = append(include, "."+clean)
+	}
+
+	return tarFilters{
+		include:          include,
+		exclude:          options.StrSlice["Exclude"],
+		excludeFile:      options.String["ExcludeFile"],
+		excludeCaches:    excludeCaches,
+		oneFileSystem:    oneFileSystem,
+		excludeIfPresent: options.String["ExcludeIfPresent"],
+	}, nil
+}
+
+// Arguments for GNU tar
+func (f tarFilters) gnuArgs() []string {
+	var args []string
+	for _, pattern := range f.exclude {
+		args = append(args, fmt.Sprintf("--exclude=%s", pattern))
+	}
+	if f.excludeFile != "" {
+		args = append(args, fmt.Sprintf("--exclude-from=%s", f.excludeFile))
+	}
+	if f.excludeCaches {
+		args = append(args, "--exclude-caches")
+	}
+	if f.oneFileSystem {
+		args = append(args, "--one-file-system")
+	}
+	if f.excludeIfPresent != "" {
+		args = append(args, fmt.Sprintf("--exclude-tag-all=%s", f.excludeIfPresent))
+	}
+	return args
+}
+
+// Paths to archive, relative to the source path
+func (f tarFilters) roots() []string {
+	if len(f.include) == 0 {
+		return []string{"."}
+	}
+	return f.include
+}
+
+// All exclusion patterns, including those read from ExcludeFile, compiled by tarPatternRegexp
+func (f tarFilters) excludePatterns() ([]*regexp.Regexp, error) {
+	patterns := append([]string{}, f.exclude...)
+	if f.excludeFile != "" {
+		data, err := os.ReadFile(f.excludeFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// Like GNU tar, leading whitespace is part of the pattern, but trailing whitespace (including
+		// the \r of CRLF) is not
+		scanner := bufio.NewScanner(bytes.NewBuffer(data))
+		for scanner.Scan() {
+			if line := strings.TrimRight(scanner.Text(), " \t\v\f\r"); line != "" {
+				patterns = append(patterns, line)
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var res []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := tarPatternRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("tar source: invalid exclusion pattern %s: %v", pattern, err)
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+// Convert an exclusion pattern into a regular expression matching it like GNU tar --exclude does
+// by default: wildcards match slashes, and the pattern is not anchored, that is it may match any
+// trailing sequence of components of the archived path
+func tarPatternRegexp(pattern string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("(?s)(?:^|/)(?:")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == 0 || (end == 1 && (pattern[i+1] == '!' || pattern[i+1] == '^')) {
+				// A closing bracket right after the opening one (or after the negation) is literal
+				if next := strings.IndexByte(pattern[i+end+2:], ']'); next >= 0 {
+					end += next + 1
+				} else {
+					end = -1
+				}
+			}
+			if end < 0 {
+				re.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+
+			class := pattern[i+1 : i+1+end]
+			re.WriteString("[")
+			if class[0] == '!' || class[0] == '^' {
+				re.WriteString("^")
+				class = class[1:]
+			}
+			for j := 0; j < len(class); j++ {
+				escaped := class[j] == '\\' && j+1 < len(class)
+				if escaped {
+					j++
+				}
+				c := class[j]
+				if (c == '-' && !escaped) || c >= utf8.RuneSelf || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+					re.WriteByte(c)
+				} else {
+					re.WriteString("\\" + class[j:j+1])
+				}
+			}
+			re.WriteString("]")
+			i += end + 1
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	re.WriteString(")$")
+	return regexp.Compile(re.String())
+}
+
+// Check if a path (relative to the source path) matches an exclusion pattern. Like for GNU tar,
+// the path is matched as ./<path>
+func tarExcluded(patterns []*regexp.Regexp, name string) bool {
+	name = "./" + filepath.ToSlash(name)
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a directory contains a valid CACHEDIR.TAG file
+func isCacheDir(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, "CACHEDIR.TAG"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(cacheDirTagSignature))
+	_, err = io.ReadFull(f, buf)
+	return err == nil && string(buf) == cacheDirTagSignature
+}
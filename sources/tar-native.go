@@ -0,0 +1,896 @@
+package sources
+
+import (
+	uback "github.com/sloonz/uback/lib"
+
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tarIndexMagic = "uback-tar-index/1"
+
+	// PAX record of the global header listing paths deleted since the base snapshot
+	tarDeletedRecord = "UBACK.deleted"
+
+	// GNU tar dumpdir entries (incremental archives), handled as directories
+	tarTypeGNUDumpDir = 'D'
+)
+
+var (
+	ErrTarIndex       = errors.New("tar source: invalid index")
+	ErrTarUnsafePath  = errors.New("tar source: unsafe path in archive")
+	errTarInvalidPath = errors.New("invalid path")
+)
+
+// State of a file at the time of a backup, used to decide whether it must be included in the next
+// incremental backup
+type tarIndexEntry struct {
+	Path  string `json:"path"`
+	Ino   uint64 `json:"ino"`
+	Mtime int64  `json:"mtime"`
+	Ctime int64  `json:"ctime"`
+	Size  int64  `json:"size"`
+}
+
+func (e tarIndexEntry) unchanged(other tarIndexEntry) bool {
+	return e.Ino == other.Ino && e.Mtime == other.Mtime && e.Ctime == other.Ctime && e.Size == other.Size
+}
+
+func readTarIndex(p string) (map[string]tarIndexEntry, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, err := br.ReadString('\n')
+	if err != nil || strings.TrimSpace(magic) != tarIndexMagic {
+		return nil, ErrTarIndex
+	}
+
+	index := make(map[string]tarIndexEntry)
+	dec := json.NewDecoder(br)
+	for {
+		var e tarIndexEntry
+		err = dec.Decode(&e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		index[e.Path] = e
+	}
+
+	return index, nil
+}
+
+func writeTarIndex(p string, entries []tarIndexEntry) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	_, err = bw.WriteString(tarIndexMagic + "\n")
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(bw)
+	for _, e := range entries {
+		err = enc.Encode(&e)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = bw.Flush()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func statIndexEntry(name string, fi fs.FileInfo) (tarIndexEntry, *syscall.Stat_t) {
+	st := fi.Sys().(*syscall.Stat_t)
+	return tarIndexEntry{
+		Path:  name,
+		Ino:   st.Ino,
+		Mtime: time.Unix(int64(st.Mtim.Sec), int64(st.Mtim.Nsec)).UnixNano(),
+		Ctime: time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec)).UnixNano(),
+		Size:  fi.Size(),
+	}, st
+}
+
+func readXattrs(p string) (map[string]string, error) {
+	size, err := unix.Llistxattr(p, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(p, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := make(map[string]string)
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		vsize, err := unix.Lgetxattr(p, name, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, vsize)
+		vsize, err = unix.Lgetxattr(p, name, value)
+		if err != nil {
+			return nil, err
+		}
+
+		xattrs[name] = string(value[:vsize])
+	}
+
+	return xattrs, nil
+}
+
+// A file found while walking the archived tree
+type tarWalkEntry struct {
+	p    string
+	name string
+	fi   fs.FileInfo
+}
+
+// Write a tar archive of basePath to w. Regular files that did not change since the base index are
+// omitted, and paths of the base index that do not exist anymore (or are now filtered out) are listed
+// in a PAX global header at the start of the archive, so that they can be removed before any other
+// entry is extracted. Return the index of the archived tree.
+func writeNativeTar(w io.Writer, basePath string, baseIndex map[string]tarIndexEntry, filters tarFilters) ([]tarIndexEntry, error) {
+	var files []tarWalkEntry
+	var index []tarIndexEntry
+	seen := make(map[string]struct{})
+	links := make(map[[2]uint64]string)
+	cacheDirs := make(map[string]struct{})
+	basePath = filepath.Clean(basePath)
+
+	excludePatterns, err := filters.excludePatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	var rootDev uint64
+	walk := func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			tarLog.Warnf("%v", err)
+			if d != nil && d.IsDir() && p != basePath {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		if p == basePath {
+			return nil
+		}
+
+		name, err := filepath.Rel(basePath, p)
+		if err != nil {
+			return err
+		}
+
+		// Filters
+		skip := func() error {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if tarExcluded(excludePatterns, name) {
+			return skip()
+		}
+		if _, ok := cacheDirs[filepath.Dir(name)]; ok && d.Name() != "CACHEDIR.TAG" {
+			return skip()
+		}
+		if d.IsDir() && filters.excludeIfPresent != "" {
+			if _, err := os.Lstat(filepath.Join(p, filters.excludeIfPresent)); err == nil {
+				return fs.SkipDir
+			}
+		}
+		if d.IsDir() && filters.excludeCaches && isCacheDir(p) {
+			cacheDirs[name] = struct{}{}
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			tarLog.Warnf("%v", err)
+			return nil
+		}
+
+		seen[name] = struct{}{}
+		files = append(files, tarWalkEntry{p: p, name: name, fi: fi})
+
+		// Do not descend into other filesystems than the one of the walked root, but keep the mount point
+		if filters.oneFileSystem && fi.IsDir() && uint64(fi.Sys().(*syscall.Stat_t).Dev) != rootDev {
+			return fs.SkipDir
+		}
+		return nil
+	}
+
+	for _, root := range filters.roots() {
+		rootInfo, err := os.Stat(filepath.Join(basePath, root))
+		if err != nil {
+			tarLog.Warnf("%v", err)
+			continue
+		}
+		rootDev = uint64(rootInfo.Sys().(*syscall.Stat_t).Dev)
+
+		err = filepath.WalkDir(filepath.Join(basePath, root), walk)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tw := tar.NewWriter(w)
+
+	var deleted []string
+	for name := range baseIndex {
+		if _, ok := seen[name]; !ok {
+			deleted = append(deleted, filepath.ToSlash(name))
+		}
+	}
+
+	if len(deleted) > 0 {
+		sort.Strings(deleted)
+		deletedJSON, err := json.Marshal(deleted)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag:   tar.TypeXGlobalHeader,
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{tarDeletedRecord: string(deletedJSON)},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	archive := func(file tarWalkEntry) error {
+		p, name, fi := file.p, file.name, file.fi
+		log := tarLog.WithFields(logrus.Fields{"file": name})
+		entry, st := statIndexEntry(name, fi)
+
+		// If the file cannot be archived, keep it in the index so that it is not considered deleted
+		baseEntry, inBase := baseIndex[name]
+		keepBase := func() {
+			if inBase {
+				index = append(index, baseEntry)
+			}
+		}
+
+		if fi.Mode().IsRegular() && inBase && baseEntry.unchanged(entry) {
+			index = append(index, entry)
+			if st.Nlink > 1 {
+				if _, ok := links[[2]uint64{uint64(st.Dev), st.Ino}]; !ok {
+					links[[2]uint64{uint64(st.Dev), st.Ino}] = name
+				}
+			}
+			return nil
+		}
+
+		link := ""
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			var err error
+			link, err = os.Readlink(p)
+			if err != nil {
+				log.Warnf("cannot read symbolic link: %v", err)
+				keepBase()
+				return nil
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			log.Warnf("skipping: %v", err)
+			keepBase()
+			return nil
+		}
+
+		hdr.Name = filepath.ToSlash(name)
+		hdr.Format = tar.FormatPAX
+		hdr.PAXRecords = make(map[string]string)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+
+		xattrs, err := readXattrs(p)
+		if err != nil {
+			log.Warnf("cannot read extended attributes: %v", err)
+		}
+		for k, v := range xattrs {
+			hdr.PAXRecords["SCHILY.xattr."+k] = v
+		}
+
+		if fi.Mode().IsRegular() && st.Nlink > 1 {
+			key := [2]uint64{uint64(st.Dev), st.Ino}
+			if first, ok := links[key]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = filepath.ToSlash(first)
+				hdr.Size = 0
+			} else {
+				links[key] = name
+			}
+		}
+
+		var f *os.File
+		if hdr.Typeflag == tar.TypeReg {
+			f, err = os.Open(p)
+			if err != nil {
+				log.Warnf("%v", err)
+				keepBase()
+				return nil
+			}
+			defer f.Close()
+		}
+
+		err = tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if f != nil {
+			n, err := io.CopyN(tw, f, hdr.Size)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if n < hdr.Size {
+				log.Warnf("file shrank while being archived")
+				_, err = io.CopyN(tw, zeroReader{}, hdr.Size-n)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		index = append(index, entry)
+		return nil
+	}
+
+	for _, file := range files {
+		err = archive(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return index, tw.Close()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// Resolve an archive path into the restoration directory, refusing paths that escape it
+func tarTargetPath(root, name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return "", errTarInvalidPath
+	}
+	return filepath.Join(root, filepath.FromSlash(clean)), nil
+}
+
+// Check that all the parents of p, up to root, are directories and not symbolic links, so that
+// writing or removing p cannot affect anything outside of root. If create is true, missing parents
+// are created and parents that are not directories are replaced by directories; otherwise, false is
+// returned if a parent is missing or is not a directory.
+func tarCheckParents(root, p string, create bool) (bool, error) {
+	rel, err := filepath.Rel(root, filepath.Dir(p))
+	if err != nil {
+		return false, err
+	}
+	if rel == "." {
+		return true, nil
+	}
+
+	dir := root
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, component)
+		fi, err := os.Lstat(dir)
+		if err == nil && fi.IsDir() {
+			continue
+		}
+		if err != nil && !errors.Is(err, syscall.ENOENT) && !errors.Is(err, syscall.ENOTDIR) {
+			return false, err
+		}
+		if !create {
+			return false, nil
+		}
+
+		if err == nil {
+			err = os.Remove(dir)
+			if err != nil {
+				return false, err
+			}
+		}
+		err = os.Mkdir(dir, 0777)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Remove a path deleted since the base snapshot from the restoration directory. If one of its
+// parents is not a directory anymore, the path is already deleted
+func tarRemoveDeleted(root, name string) error {
+	p, err := tarTargetPath(root, name)
+	if err != nil {
+		return ErrTarUnsafePath
+	}
+
+	ok, err := tarCheckParents(root, p, false)
+	if err != nil || !ok {
+		return err
+	}
+
+	err = os.RemoveAll(p)
+	if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+		return nil
+	}
+	return err
+}
+
+// Remove what exists at p, unless it is a directory and keepDir is true
+func tarRemoveExisting(p string, keepDir bool) error {
+	fi, err := os.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() && keepDir {
+		return nil
+	}
+	return os.RemoveAll(p)
+}
+
+func tarRestoreMetadata(p string, hdr *tar.Header) {
+	log := tarLog.WithFields(logrus.Fields{"file": hdr.Name})
+
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(p, hdr.Uid, hdr.Gid); err != nil {
+			log.Warnf("cannot restore owner: %v", err)
+		}
+	}
+
+	for k, v := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "SCHILY.xattr.") {
+			if err := unix.Lsetxattr(p, strings.TrimPrefix(k, "SCHILY.xattr."), []byte(v), 0); err != nil {
+				log.Warnf("cannot restore extended attribute %s: %v", strings.TrimPrefix(k, "SCHILY.xattr."), err)
+			}
+		}
+	}
+
+	if hdr.Typeflag != tar.TypeSymlink {
+		if err := os.Chmod(p, hdr.FileInfo().Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			log.Warnf("cannot restore mode: %v", err)
+		}
+	}
+
+	ts := []unix.Timespec{unix.NsecToTimespec(hdr.ModTime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+	if !hdr.AccessTime.IsZero() {
+		ts[0] = unix.NsecToTimespec(hdr.AccessTime.UnixNano())
+	}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, p, ts, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		log.Warnf("cannot restore modification time: %v", err)
+	}
+}
+
+// Extract a tar archive into root. Paths listed as deleted by writeNativeTar are removed. If
+// patterns is not empty, only entries matching one of them (see uback.MatchBackupPath) are
+// extracted
+func extractTar(root string, r io.Reader, patterns []string) error {
+	var dirs []*tar.Header
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			if deletedJSON, ok := hdr.PAXRecords[tarDeletedRecord]; ok {
+				var deleted []string
+				err = json.Unmarshal([]byte(deletedJSON), &deleted)
+				if err != nil {
+					return err
+				}
+
+				for _, name := range deleted {
+					err = tarRemoveDeleted(root, name)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+
+		p, err := tarTargetPath(root, hdr.Name)
+		if hdr.Typeflag == tarTypeGNUDumpDir {
+			dir := p
+			if err == errTarInvalidPath {
+				dir = root
+			}
+			err = removeGNUDumpDirDeleted(root, dir, tr)
+			if err != nil {
+				return err
+			}
+			p, err = tarTargetPath(root, hdr.Name)
+		}
+		if err == errTarInvalidPath {
+			// Root directory
+			continue
+		}
+
+		if len(patterns) > 0 {
+			if !uback.MatchBackupPath(patterns, hdr.Name) {
+				continue
+			}
+			if hdr.Typeflag == tar.TypeLink && !uback.MatchBackupPath(patterns, hdr.Linkname) {
+				tarLog.WithFields(logrus.Fields{"file": hdr.Name}).Warnf("skipping hard link to %s, which is not restored", hdr.Linkname)
+				continue
+			}
+		}
+
+		err = extractTarEntry(root, p, hdr, tr)
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tarTypeGNUDumpDir {
+			dirs = append(dirs, hdr)
+		}
+	}
+
+	// Directories metadata is restored last, deepest first, so that restoring files in them is not
+	// affected by their mode and does not change their modification time
+	for i := len(dirs) - 1; i >= 0; i-- {
+		p, _ := tarTargetPath(root, dirs[i].Name)
+		// The directory may have been replaced by a later entry
+		fi, err := os.Lstat(p)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+		if ok, err := tarCheckParents(root, p, false); err != nil || !ok {
+			continue
+		}
+		tarRestoreMetadata(p, dirs[i])
+	}
+
+	return nil
+}
+
+// Apply a tar archive onto the listing of its base. Paths listed as deleted by writeNativeTar are
+// removed from the listing
+func listTar(entries map[string]uback.BackupEntry, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			if deletedJSON, ok := hdr.PAXRecords[tarDeletedRecord]; ok {
+				var deletedList []string
+				err = json.Unmarshal([]byte(deletedJSON), &deletedList)
+				if err != nil {
+					return err
+				}
+
+				deleted := make(map[string]bool)
+				for _, name := range deletedList {
+					deleted[tarEntryPath(name)] = true
+				}
+				deleteTarEntries(entries, deleted)
+			}
+			continue
+		}
+
+		name := tarEntryPath(hdr.Name)
+		if hdr.Typeflag == tarTypeGNUDumpDir {
+			children, err := readGNUDumpDir(tr)
+			if err != nil {
+				return err
+			}
+
+			deleted := make(map[string]bool)
+			for p := range entries {
+				if tarEntryDir(p) == name && !children[path.Base(p)] {
+					deleted[p] = true
+				}
+			}
+			deleteTarEntries(entries, deleted)
+		}
+		if name == "" {
+			// Root directory
+			continue
+		}
+
+		mode := hdr.FileInfo().Mode()
+		if hdr.Typeflag == tarTypeGNUDumpDir {
+			mode |= fs.ModeDir
+		}
+
+		entry := uback.BackupEntry{
+			Path:     name,
+			Mode:     mode,
+			Size:     hdr.Size,
+			ModTime:  hdr.ModTime,
+			Linkname: hdr.Linkname,
+		}
+
+		if hdr.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			_, err = io.Copy(h, tr)
+			if err != nil {
+				return err
+			}
+			entry.Hash = hex.EncodeToString(h.Sum(nil))
+		}
+
+		entries[name] = entry
+	}
+}
+
+// Normalize an archive path into a uback.BackupEntry path
+func tarEntryPath(name string) string {
+	return strings.Trim(path.Clean("/"+name), "/")
+}
+
+// Parent of a uback.BackupEntry path, "" being the root directory
+func tarEntryDir(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// Remove the deleted paths, and their children, from a listing
+func deleteTarEntries(entries map[string]uback.BackupEntry, deleted map[string]bool) {
+	if len(deleted) == 0 {
+		return
+	}
+
+	for name := range entries {
+		for p := name; p != "."; p = path.Dir(p) {
+			if deleted[p] {
+				delete(entries, name)
+				break
+			}
+		}
+	}
+}
+
+// Read the content of a GNU dump directory entry, which lists the names of all the files of the
+// directory at the time of the backup
+func readGNUDumpDir(r io.Reader) (map[string]bool, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, item := range strings.Split(string(data), "\x00") {
+		// Each name is prefixed by a control code ; other codes are used for renames, which
+		// are not needed to detect deleted files
+		if len(item) > 1 && strings.ContainsRune("YND", rune(item[0])) {
+			names[item[1:]] = true
+		}
+	}
+
+	return names, nil
+}
+
+// Remove the files of a directory that are not listed in its GNU dump directory entry, like
+// tar --listed-incremental does. Nothing is removed if dir is not a directory of root
+func removeGNUDumpDirDeleted(root, dir string, data io.Reader) error {
+	names, err := readGNUDumpDir(data)
+	if err != nil {
+		return err
+	}
+
+	if dir != root {
+		fi, err := os.Lstat(dir)
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		if ok, err := tarCheckParents(root, dir, false); err != nil || !ok {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !names[entry.Name()] {
+			err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func extractTarEntry(root, p string, hdr *tar.Header, data io.Reader) error {
+	log := tarLog.WithFields(logrus.Fields{"file": hdr.Name})
+
+	_, err := tarCheckParents(root, p, true)
+	if err != nil {
+		return err
+	}
+
+	isDir := hdr.Typeflag == tar.TypeDir || hdr.Typeflag == tarTypeGNUDumpDir
+	err = tarRemoveExisting(p, isDir)
+	if err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir, tarTypeGNUDumpDir:
+		return os.MkdirAll(p, 0700)
+
+	case tar.TypeReg, tar.TypeGNUSparse:
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, data)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		err = os.Symlink(hdr.Linkname, p)
+		if err != nil {
+			return err
+		}
+
+	case tar.TypeLink:
+		target, err := tarTargetPath(root, hdr.Linkname)
+		if err != nil {
+			return ErrTarUnsafePath
+		}
+		if ok, err := tarCheckParents(root, target, false); err != nil {
+			return err
+		} else if !ok {
+			return ErrTarUnsafePath
+		}
+		// Metadata is shared with the link target
+		return os.Link(target, p)
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(hdr.Mode & 07777)
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			mode |= unix.S_IFCHR
+		case tar.TypeBlock:
+			mode |= unix.S_IFBLK
+		case tar.TypeFifo:
+			mode |= unix.S_IFIFO
+		}
+		err = unix.Mknod(p, mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+		if err != nil {
+			log.Warnf("cannot create special file: %v", err)
+			return nil
+		}
+
+	default:
+		log.Warnf("skipping unsupported entry type %v", fmt.Sprintf("%q", hdr.Typeflag))
+		return nil
+	}
+
+	tarRestoreMetadata(p, hdr)
+	return nil
+}
+
+// Used by tarSource in native mode
+func (s *tarSource) createNativeBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io.ReadCloser, error) {
+	snapshot := time.Now().UTC().Format(uback.SnapshotTimeFormat)
+	tmpSnapshotPath := path.Join(s.snapshotsPath, fmt.Sprintf("_tmp-%s", snapshot))
+	finalSnapshotPath := path.Join(s.snapshotsPath, snapshot)
+
+	if s.snapshotsPath == "" {
+		baseSnapshot = nil
+	}
+
+	var baseIndex map[string]tarIndexEntry
+	if baseSnapshot != nil {
+		var err error
+		baseIndex, err = readTarIndex(path.Join(s.snapshotsPath, baseSnapshot.Name()))
+		if err == ErrTarIndex {
+			tarLog.WithFields(logrus.Fields{"snapshot": baseSnapshot.Name()}).Warnf("base snapshot has been created in GNU mode, forcing full backup")
+			baseSnapshot = nil
+			baseIndex = nil
+		} else if err != nil {
+			tarLog.WithFields(logrus.Fields{"snapshot": baseSnapshot.Name()}).Warnf("failed to read base snapshot (%v), forcing full backup", err)
+			baseSnapshot = nil
+			baseIndex = nil
+		}
+	}
+
+	backup := uback.Backup{Snapshot: uback.Snapshot(snapshot), BaseSnapshot: baseSnapshot}
+	tarLog.Printf("creating backup: %s", backup.Filename())
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		index, err := writeNativeTar(pw, s.basePath, baseIndex, s.filters)
+		if err == nil && s.snapshotsPath != "" {
+			err = writeTarIndex(tmpSnapshotPath, index)
+			if err == nil {
+				err = os.Rename(tmpSnapshotPath, finalSnapshotPath)
+			}
+			if err != nil {
+				os.Remove(tmpSnapshotPath)
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	// When closed early, wait for the archive to be aborted and the temporary index to be removed
+	return backup, uback.WrapCleanup(pr, func() error {
+		<-done
+		return nil
+	}), nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path"
@@ -17,8 +18,9 @@ import (
 )
 
 var (
-	ErrTarPath = errors.New("tar source: invalid path")
-	tarLog     = logrus.WithFields(logrus.Fields{
+	ErrTarPath  = errors.New("tar source: invalid path")
+	ErrTarPaths = errors.New("tar source: @Paths cannot be combined with Path or @Include")
+	tarLog      = logrus.WithFields(logrus.Fields{
 		"source": "tar",
 	})
 )
@@ -28,6 +30,8 @@ type tarSource struct {
 	snapshotsPath string
 	basePath      string
 	command       []string
+	native        bool
+	filters       tarFilters
 }
 
 func newTarSource(options *uback.Options) (uback.Source, error) {
@@ -41,14 +45,42 @@ func newTarSource(options *uback.Options) (uback.Source, error) {
 		}
 	}
 
+	// With @Paths, archive the given absolute paths as if they were included from the root
 	basePath := options.String["Path"]
+	paths := options.StrSlice["Paths"]
+	if len(paths) > 0 {
+		if basePath != "" || len(options.StrSlice["Include"]) > 0 {
+			return nil, ErrTarPaths
+		}
+		basePath = "/"
+	}
 	if basePath == "" {
 		return nil, ErrTarPath
 	}
 
+	native, err := options.GetBoolean("Native", false)
+	if err != nil {
+		return nil, err
+	}
+
+	filters, err := newTarFilters(options)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range paths {
+		if !path.IsAbs(p) {
+			return nil, fmt.Errorf("tar source: path must be absolute: %s", p)
+		}
+		if path.Clean(p) == "/" {
+			return nil, fmt.Errorf("tar source: use Path=/ to archive the whole filesystem")
+		}
+		filters.include = append(filters.include, "."+path.Clean(p))
+	}
+
 	command := options.GetCommand("Command", []string{"tar"})
 
-	return &tarSource{options: options, snapshotsPath: snapshotsPath, basePath: basePath, command: command}, nil
+	return &tarSource{options: options, snapshotsPath: snapshotsPath, basePath: basePath, command: command, native: native, filters: filters}, nil
 }
 
 func newTarSourceForRestoration() (uback.Source, error) {
@@ -105,6 +137,10 @@ func (s *tarSource) RemoveBookmark(snapshot uback.Snapshot) error {
 
 // Part of uback.Source interface
 func (s *tarSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io.ReadCloser, error) {
+	if s.native {
+		return s.createNativeBackup(baseSnapshot)
+	}
+
 	snapshot := time.Now().UTC().Format(uback.SnapshotTimeFormat)
 	tmpSnapshotPath := path.Join(s.snapshotsPath, fmt.Sprintf("_tmp-%s", snapshot))
 	finalSnapshotPath := path.Join(s.snapshotsPath, snapshot)
@@ -113,6 +149,13 @@ func (s *tarSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io
 		baseSnapshot = nil
 	}
 
+	if baseSnapshot != nil {
+		if _, err := readTarIndex(path.Join(s.snapshotsPath, baseSnapshot.Name())); err == nil {
+			tarLog.WithFields(logrus.Fields{"snapshot": baseSnapshot.Name()}).Warnf("base snapshot has been created in native mode, forcing full backup")
+			baseSnapshot = nil
+		}
+	}
+
 	if baseSnapshot != nil {
 		err := uback.CopyFile(tmpSnapshotPath, path.Join(s.snapshotsPath, baseSnapshot.Name()))
 		if err != nil {
@@ -129,7 +172,8 @@ func (s *tarSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io
 	if s.snapshotsPath != "" {
 		args = append(args, fmt.Sprintf("--listed-incremental=%s", tmpSnapshotPath))
 	}
-	args = append(args, ".")
+	args = append(args, s.filters.gnuArgs()...)
+	args = append(args, s.filters.roots()...)
 	return uback.WrapSourceCommand(backup, uback.BuildCommand(s.command, args...), func(err error) error {
 		// For tar, exit code 1 is a warning, don't treat it as an error
 		if err != nil {
@@ -150,6 +194,20 @@ func (s *tarSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io
 
 // Part of uback.Source interface
 func (s *tarSource) RestoreBackup(targetDir string, backup uback.Backup, data io.Reader) error {
+	return s.restore(targetDir, backup, data, nil)
+}
+
+// Part of uback.BrowsableSource interface
+func (s *tarSource) RestorePartialBackup(targetDir string, backup uback.Backup, data io.Reader, patterns []string) error {
+	return s.restore(targetDir, backup, data, patterns)
+}
+
+// Part of uback.BrowsableSource interface
+func (s *tarSource) ListBackupEntries(entries map[string]uback.BackupEntry, backup uback.Backup, data io.Reader) error {
+	return listTar(entries, data)
+}
+
+func (s *tarSource) restore(targetDir string, backup uback.Backup, data io.Reader, patterns []string) error {
 	err := os.RemoveAll(path.Join(targetDir, backup.Snapshot.Name()))
 	if err != nil {
 		return err
@@ -164,7 +222,46 @@ func (s *tarSource) RestoreBackup(targetDir string, backup uback.Backup, data io
 		return err
 	}
 
-	cmd := exec.Command("tar", "-x", "-C", path.Join(targetDir, backup.Snapshot.Name()))
-	cmd.Stdin = data
-	return uback.RunCommand(tarLog, cmd)
+	tarLog.Printf("extracting %s onto %s", backup.Filename(), path.Join(targetDir, backup.Snapshot.Name()))
+	return extractTar(path.Join(targetDir, backup.Snapshot.Name()), data, patterns)
+}
+
+// Part of uback.SizeEstimator interface
+func (s *tarSource) EstimateBackupSize(baseSnapshot *uback.Snapshot) (int64, error) {
+	var since time.Time
+	if baseSnapshot != nil && s.snapshotsPath != "" {
+		var err error
+		since, err = baseSnapshot.Time()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	excludePatterns, err := s.filters.excludePatterns()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, root := range s.filters.roots() {
+		prefix := path.Clean(root)
+		rootSize, err := estimateTreeSize(tarLog, path.Join(s.basePath, root), since, func(name string, p string, d fs.DirEntry) bool {
+			name = path.Join(prefix, name)
+			if tarExcluded(excludePatterns, name) {
+				return true
+			}
+			if d.IsDir() && s.filters.excludeIfPresent != "" {
+				if _, err := os.Lstat(path.Join(p, s.filters.excludeIfPresent)); err == nil {
+					return true
+				}
+			}
+			return d.IsDir() && s.filters.excludeCaches && isCacheDir(p)
+		})
+		if err != nil {
+			return 0, err
+		}
+		size += rootSize
+	}
+
+	return size, nil
 }
@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -236,7 +237,15 @@ func (s *zfsSource) CreateBackup(baseSnapshot *uback.Snapshot) (uback.Backup, io
 		}
 	}
 	args = append(args, s.dataset+"@"+s.prefix+snapshot)
-	return uback.WrapSourceCommand(backup, uback.BuildCommand(s.sendCommand, args...), nil)
+	return uback.WrapSourceCommand(backup, uback.BuildCommand(s.sendCommand, args...), func(err error) error {
+		if !reused && err != nil {
+			if s.useBookmarks {
+				_ = s.RemoveBookmark(uback.Snapshot(snapshot))
+			}
+			_ = s.RemoveArchive(uback.Snapshot(snapshot))
+		}
+		return err
+	})
 }
 
 // Part of uback.Source interface
@@ -250,3 +259,41 @@ func (s *zfsSource) RestoreBackup(targetDir string, backup uback.Backup, data io
 
 	return nil
 }
+
+// Part of uback.SizeEstimator interface. Use the referenced property for a full backup, and the
+// written@base (or written#base) property for an incremental backup. This is only a rough estimate
+// of the size of the stream, since zfs send -nvP would require a snapshot of the current state.
+func (s *zfsSource) EstimateBackupSize(baseSnapshot *uback.Snapshot) (int64, error) {
+	property := "referenced"
+	if baseSnapshot != nil {
+		if s.useBookmarks {
+			property = "written#" + s.prefix + baseSnapshot.Name()
+		} else {
+			property = "written@" + s.prefix + baseSnapshot.Name()
+		}
+	}
+
+	args := []string{"-H", "-p", "-o", property}
+	if s.replicate {
+		args = append(args, "-r")
+	}
+	args = append(args, s.dataset)
+
+	buf := bytes.NewBuffer(nil)
+	cmd := uback.BuildCommand(s.listCommand, args...)
+	cmd.Stdout = buf
+	if err := uback.RunCommand(zfsLog, cmd); err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, line := range strings.Fields(buf.String()) {
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot parse %s property: %v", property, err)
+		}
+		size += n
+	}
+
+	return size, nil
+}
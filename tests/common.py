@@ -3,6 +3,7 @@ import os
 import pathlib
 import shlex
 import shutil
+import signal
 import subprocess
 import tempfile
 import time
@@ -20,6 +21,17 @@ def ensure_dir(d):
     if not os.path.exists(d):
         os.mkdir(d)
 
+def write_stub(d, name, content):
+    with open(f"{d}/{name}", "w+") as fd: fd.write(content)
+    os.chmod(f"{d}/{name}", 0o755)
+
+def stub_env(path=None, **variables):
+    """Environment for commands using stubs, without modifying the environment of other tests"""
+    env = {**os.environ, **variables}
+    if path is not None:
+        env["PATH"] = f"{path}:{env['PATH']}"
+    return env
+
 def check_call(cmd, *args, **kwargs):
     print(shlex.join([str(arg) for arg in cmd]), file=sys.stderr)
     return subprocess.check_call(cmd, *args, **kwargs)
@@ -7,3 +7,23 @@ class DestCommandTests(unittest.TestCase, DestBaseTests):
             source = f"type=tar,path={d}/source,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly"
             dest = f"id=test,type=command,command=uback-fs-dest,path={d}/backups,@retention-policy=daily=3,key-file={d}/backup.key"
             self._test_dest(d, source, dest)
+
+    def test_command_destination_timeout(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.environ["PATH"] = ":".join((str(tests_path), os.environ["PATH"]))
+            with open(f"{d}/slow-src", "w+") as fd:
+                fd.write("#!/bin/sh\n")
+                fd.write('case "$2" in\n')
+                fd.write("  type) echo command:slow ;;\n")
+                fd.write("  create-backup) echo 20210102T030405.000-full; head -c 1000 /dev/zero; exec sleep 60 ;;\n")
+                fd.write("esac\n")
+            os.chmod(f"{d}/slow-src", 0o755)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            source = f"type=command,command={d}/slow-src,key-file={d}/backup.pub"
+            dest = f"id=test,type=command,command=uback-fs-dest,path={d}/backups,timeout=1s"
+
+            # The truncated backup must not be stored
+            p = run([uback, "backup", source, dest], stdout=subprocess.PIPE, stderr=subprocess.PIPE)
+            self.assertNotEqual(p.returncode, 0)
+            self.assertIn(b"destination timeout (1s) exceeded", p.stderr)
+            self.assertEqual([f for f in os.listdir(f"{d}/backups") if not f.startswith("_")], [])
@@ -0,0 +1,85 @@
+from .common import *
+
+class HooksTests(unittest.TestCase):
+    def _write_hook(self, d, exit_code=0):
+        with open(f"{d}/hook.sh", "w+") as fd:
+            fd.write("#!/bin/sh\n")
+            fd.write(f'echo "$UBACK_HOOK $UBACK_COMMAND $UBACK_STATUS $UBACK_BACKUP $UBACK_DESTINATION_ID $UBACK_SRC_OPT_TYPE $1" >> {d}/hooks.log\n')
+            fd.write('if [ -n "$UBACK_ERROR" ]; then echo "error: $UBACK_ERROR" >> ' + f"{d}/hooks.log; fi\n")
+            fd.write(f"exit {exit_code}\n")
+        os.chmod(f"{d}/hook.sh", 0o755)
+
+    def _read_log(self, d):
+        if not os.path.exists(f"{d}/hooks.log"):
+            return []
+        lines = read_file(f"{d}/hooks.log", "r").splitlines()
+        os.unlink(f"{d}/hooks.log")
+        return lines
+
+    def test_backup_restore_hooks(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._write_hook(d)
+            ensure_dir(f"{d}/source")
+            ensure_dir(f"{d}/restore")
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            with open(f"{d}/source/a", "w+") as fd: fd.write("a")
+
+            hooks = f"@pre-command={d}/hook.sh,@pre-command=src,@post-command={d}/hook.sh,@post-command=src,on-success={d}/hook.sh src,on-failure={d}/hook.sh src"
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub,{hooks}"
+            dest = f"id=test,type=fs,path={d}/backups,on-success={d}/hook.sh dst"
+
+            b = check_output([uback, "backup", source, dest]).strip().decode()
+            self.assertEqual(self._read_log(d), [
+                "PreCommand backup   test tar src",
+                f"OnSuccess backup success {b} test tar src",
+                f"OnSuccess backup success {b} test tar dst",
+                f"PostCommand backup success {b} test tar src",
+            ])
+
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key,pre-command={d}/hook.sh dst,post-command={d}/hook.sh dst"
+            check_call([uback, "restore", "-d", f"{d}/restore", dest])
+            self.assertEqual(self._read_log(d), [
+                "PreCommand restore   test  dst",
+                f"PostCommand restore success {b} test  dst",
+            ])
+
+            # Backup failure: OnFailure and PostCommand are run, with the error
+            with open(f"{d}/failing-dest.sh", "w+") as fd:
+                fd.write('#!/bin/sh\ncat > /dev/null\n[ "$2" = send-backup ] && exit 1\nexit 0\n')
+            os.chmod(f"{d}/failing-dest.sh", 0o755)
+            dest = f"id=test,type=command,command={d}/failing-dest.sh"
+            self.assertNotEqual(0, run([uback, "backup", source, dest]).returncode)
+            log = self._read_log(d)
+            self.assertEqual(log[0], "PreCommand backup   test tar src")
+            self.assertTrue(log[1].startswith("OnFailure backup failure"))
+            self.assertTrue(log[2].startswith("error: "))
+            self.assertTrue(log[3].startswith("PostCommand backup failure"))
+
+    def test_hook_failure_policy(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._write_hook(d, 1)
+            ensure_dir(f"{d}/source")
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            with open(f"{d}/source/a", "w+") as fd: fd.write("a")
+
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub,pre-command={d}/hook.sh"
+            dest = f"id=test,type=fs,path={d}/backups"
+
+            # abort (default): no backup is made
+            self.assertNotEqual(0, run([uback, "backup", source, dest]).returncode)
+            self.assertFalse(os.path.exists(f"{d}/backups") and os.listdir(f"{d}/backups"))
+            self._read_log(d)
+
+            # warn: the backup is made anyway
+            check_call([uback, "backup", source + ",hook-failure=warn", dest])
+            self.assertEqual(1, len(glob.glob(f"{d}/backups/*.ubkp")))
+            self._read_log(d)
+
+            # timeout
+            with open(f"{d}/slow.sh", "w+") as fd: fd.write("#!/bin/sh\nsleep 10\n")
+            os.chmod(f"{d}/slow.sh", 0o755)
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub,pre-command={d}/slow.sh,hook-timeout=100ms"
+            start = time.time()
+            self.assertNotEqual(0, run([uback, "backup", source, dest]).returncode)
+            self.assertLess(time.time() - start, 5)
+            self.assertEqual(1, len(glob.glob(f"{d}/backups/*.ubkp")))
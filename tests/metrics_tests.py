@@ -0,0 +1,58 @@
+from .common import *
+
+def parse_metrics(path):
+    metrics = {}
+    for line in read_file(path, "r").splitlines():
+        if line.startswith("#"):
+            continue
+        name, value = line.rsplit(" ", 1)
+        metrics[name] = float(value)
+    return metrics
+
+class MetricsTests(unittest.TestCase):
+    def test_metrics_textfile(self):
+        with tempfile.TemporaryDirectory() as d:
+            ensure_dir(f"{d}/source")
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            with open(f"{d}/source/a", "w+") as fd: fd.write("a" * 1000)
+
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub"
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=1"
+            labels = '{command="backup",source_type="tar",destination_type="fs",destination_id="test"}'
+
+            pathlib.Path(f"{d}/backups").mkdir()
+            pathlib.Path(f"{d}/backups/20210101T000000.000-full.ubkp").touch()
+            start = time.time()
+            check_call([uback, "backup", "--metrics-textfile", f"{d}/uback.prom", source, dest])
+            metrics = parse_metrics(f"{d}/uback.prom")
+            self.assertEqual(metrics[f"uback_last_run_success{labels}"], 1)
+            self.assertAlmostEqual(metrics[f"uback_last_success_timestamp_seconds{labels}"], start, delta=5)
+            self.assertEqual(metrics[f"uback_last_success_timestamp_seconds{labels}"], metrics[f"uback_last_run_timestamp_seconds{labels}"])
+            self.assertGreater(metrics[f"uback_last_run_read_bytes{labels}"], 1000)
+            self.assertGreater(metrics[f"uback_last_run_written_bytes{labels}"], 0)
+            self.assertEqual(metrics[f"uback_backups{labels}"], 1)
+            self.assertEqual(metrics[f"uback_chain_length{labels}"], 1)
+            self.assertEqual(metrics[f"uback_last_run_pruned_backups{labels}"], 1)
+            last_success = metrics[f"uback_last_success_timestamp_seconds{labels}"]
+
+            # A failed run keeps the last success timestamp
+            time.sleep(1)
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub,pre-command=false"
+            self.assertNotEqual(0, run([uback, "backup", "--metrics-textfile", f"{d}/uback.prom", source, dest]).returncode)
+            metrics = parse_metrics(f"{d}/uback.prom")
+            self.assertEqual(metrics[f"uback_last_run_success{labels}"], 0)
+            self.assertEqual(metrics[f"uback_last_success_timestamp_seconds{labels}"], last_success)
+            self.assertGreater(metrics[f"uback_last_run_timestamp_seconds{labels}"], last_success)
+            self.assertNotIn(f"uback_backups{labels}", metrics)
+
+            labels = '{command="prune backups",source_type="",destination_type="fs",destination_id="test"}'
+            pathlib.Path(f"{d}/backups/20201231T000000.000-full.ubkp").touch()
+            for opt in ("--dry-run", "--explain"):
+                check_call([uback, "prune", "backups", opt, "--metrics-textfile", f"{d}/prune.prom", dest])
+                self.assertFalse(os.path.exists(f"{d}/prune.prom"))
+            check_call([uback, "prune", "backups", "--metrics-textfile", f"{d}/prune.prom", dest])
+            metrics = parse_metrics(f"{d}/prune.prom")
+            self.assertEqual(metrics[f"uback_last_run_success{labels}"], 1)
+            self.assertEqual(metrics[f"uback_last_run_pruned_backups{labels}"], 1)
+            self.assertEqual(metrics[f"uback_backups{labels}"], 1)
+            self.assertEqual(set(os.listdir(d)) & {"uback.prom.tmp", "prune.prom.tmp"}, set())
@@ -0,0 +1,88 @@
+from .common import *
+
+class MountTests(unittest.TestCase):
+    def _mount(self, d, dest):
+        os.mkdir(f"{d}/mnt")
+        print(shlex.join([str(uback), "mount", "-c", f"{d}/cache", dest, f"{d}/mnt"]), file=sys.stderr)
+        proc = subprocess.Popen([uback, "mount", "-c", f"{d}/cache", dest, f"{d}/mnt"])
+        for _ in range(50):
+            if os.path.ismount(f"{d}/mnt"):
+                break
+            time.sleep(0.1)
+        self.assertTrue(os.path.ismount(f"{d}/mnt"))
+        return proc
+
+    def _unmount(self, proc):
+        proc.terminate()
+        self.assertEqual(proc.wait(timeout=10), 0)
+
+    def test_mount_tar(self):
+        with tempfile.TemporaryDirectory() as d:
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            os.makedirs(f"{d}/source/sub")
+            with open(f"{d}/source/a", "w+") as fd: fd.write("av1")
+            with open(f"{d}/source/sub/b", "w+") as fd: fd.write("b")
+            os.chmod(f"{d}/source/sub/b", 0o600)
+            os.symlink("sub/b", f"{d}/source/link")
+            s1 = check_output([uback, "backup", source, dest]).strip().decode().split("-")[0]
+            time.sleep(0.01)
+            with open(f"{d}/source/a", "w+") as fd: fd.write("av2")
+            os.unlink(f"{d}/source/sub/b")
+            s2 = check_output([uback, "backup", source, dest]).strip().decode().split("-")[0]
+
+            proc = self._mount(d, dest)
+            try:
+                self.assertEqual(set(os.listdir(f"{d}/mnt")), {s1, s2})
+                self.assertEqual(set(os.listdir(f"{d}/mnt/{s1}")), {"a", "sub", "link"})
+                self.assertEqual(b"av1", read_file(f"{d}/mnt/{s1}/a"))
+                self.assertEqual(b"b", read_file(f"{d}/mnt/{s1}/sub/b"))
+                self.assertEqual(0o600, os.stat(f"{d}/mnt/{s1}/sub/b").st_mode & 0o777)
+                self.assertEqual("sub/b", os.readlink(f"{d}/mnt/{s1}/link"))
+                self.assertEqual(b"av2", read_file(f"{d}/mnt/{s2}/a"))
+                self.assertEqual(os.listdir(f"{d}/mnt/{s2}/sub"), [])
+                with self.assertRaises(OSError):
+                    open(f"{d}/mnt/{s2}/a", "w")
+            finally:
+                self._unmount(proc)
+
+            # Fetched backups are kept in the cache, but not decrypted files
+            self.assertEqual(os.listdir(f"{d}/cache"), ["backups"])
+            self.assertEqual(len(os.listdir(f"{d}/cache/backups")), 2)
+
+    def test_mount_stream(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.environ["PATH"] = ":".join((str(tests_path), os.environ["PATH"]))
+            source = f"type=command,command=uback-tar-src,path={d}/source,key-file={d}/backup.pub,snapshots-path={d}/snapshots"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            os.mkdir(f"{d}/source")
+            with open(f"{d}/source/a", "w+") as fd: fd.write("a")
+            b = check_output([uback, "backup", source, dest]).strip().decode()
+            s = b.split("-")[0]
+
+            proc = self._mount(d, dest)
+            try:
+                self.assertEqual(os.listdir(f"{d}/mnt/{s}"), [f"{b}.command:uback-tar-src"])
+                os.mkdir(f"{d}/restore")
+                check_call(["tar", "-C", f"{d}/restore", "-x", "-f", f"{d}/mnt/{s}/{b}.command:uback-tar-src"])
+                self.assertEqual(b"a", read_file(f"{d}/restore/a"))
+            finally:
+                self._unmount(proc)
+
+            # No decrypted stream remains in the cache after unmounting
+            self.assertEqual(os.listdir(f"{d}/cache"), ["backups"])
+            for f in os.listdir(f"{d}/cache/backups"):
+                self.assertTrue(read_file(f"{d}/cache/backups/{f}").startswith(b"github.com/sloonz/uback/v0\n"))
+
+    def test_mount_failure_cleanup(self):
+        with tempfile.TemporaryDirectory() as d:
+            dest = f"id=test,type=fs,path={d}/backups,no-encryption=1"
+            os.mkdir(f"{d}/backups")
+            os.mkdir(f"{d}/tmp")
+
+            # The temporary cache directory is removed even if mounting fails
+            res = run([uback, "mount", dest, f"{d}/missing"], env=stub_env(TMPDIR=f"{d}/tmp"))
+            self.assertNotEqual(res.returncode, 0)
+            self.assertEqual(os.listdir(f"{d}/tmp"), [])
@@ -0,0 +1,101 @@
+import http.server
+import json
+import threading
+
+from .common import *
+
+class NotifyTests(unittest.TestCase):
+    def setUp(self):
+        self.requests = []
+        tests = self
+
+        class Handler(http.server.BaseHTTPRequestHandler):
+            def do_POST(self):
+                body = self.rfile.read(int(self.headers["Content-Length"]))
+                tests.requests.append((self.path, self.headers["Content-Type"], json.loads(body)))
+                self.send_response(204)
+                self.end_headers()
+
+            def log_message(self, *args):
+                pass
+
+        self.server = http.server.HTTPServer(("127.0.0.1", 0), Handler)
+        self.url = f"http://127.0.0.1:{self.server.server_port}"
+        self.thread = threading.Thread(target=self.server.serve_forever)
+        self.thread.start()
+
+    def tearDown(self):
+        self.server.shutdown()
+        self.server.server_close()
+        self.thread.join()
+
+    def test_notify_url(self):
+        with tempfile.TemporaryDirectory() as d:
+            ensure_dir(f"{d}/source")
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            with open(f"{d}/source/a", "w+") as fd: fd.write("a" * 1000)
+
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub,job-name=nightly,notify-url={self.url}/src"
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=1,notify-url={self.url}/dst,notify-on=failure"
+
+            b = check_output([uback, "backup", source, dest]).strip().decode()
+            self.assertEqual(len(self.requests), 1)
+            path, content_type, report = self.requests[0]
+            self.assertEqual(path, "/src")
+            self.assertEqual(content_type, "application/json")
+            self.assertEqual(report["job"], "nightly")
+            self.assertEqual(report["command"], "backup")
+            self.assertEqual(report["status"], "success")
+            self.assertEqual(report["backup"], b)
+            self.assertEqual(report["sourceType"], "tar")
+            self.assertEqual(report["destinationId"], "test")
+            self.assertGreater(report["bytesRead"], 1000)
+            self.assertGreater(report["bytesWritten"], 0)
+            self.assertGreaterEqual(report["duration"], 0)
+            self.assertNotIn("error", report)
+
+            # Failed backup: notifies both the source and the destination
+            self.requests.clear()
+            with open(f"{d}/failing-dest.sh", "w+") as fd:
+                fd.write('#!/bin/sh\ncat > /dev/null\n[ "$2" = send-backup ] && exit 1\nexit 0\n')
+            os.chmod(f"{d}/failing-dest.sh", 0o755)
+            dest = f"id=test,type=command,command={d}/failing-dest.sh,notify-url={self.url}/dst,notify-on=failure"
+            self.assertNotEqual(0, run([uback, "backup", source, dest]).returncode)
+            self.assertEqual([r[0] for r in self.requests], ["/src", "/dst"])
+            self.assertEqual(self.requests[1][2]["status"], "failure")
+            self.assertIn("exit status 1", self.requests[1][2]["error"])
+
+            # Prune
+            self.requests.clear()
+            pathlib.Path(f"{d}/backups/20210101T000000.000-full.ubkp").touch()
+            for opt in ("--dry-run", "--explain"):
+                check_call([uback, "prune", "backups", opt, f"id=test,type=fs,path={d}/backups,@retention-policy=daily=1,notify-url={self.url}/prune"])
+            self.assertEqual(self.requests, [])
+            check_call([uback, "prune", "backups", f"id=test,type=fs,path={d}/backups,@retention-policy=daily=1,notify-url={self.url}/prune"])
+            self.assertEqual(len(self.requests), 1)
+            self.assertEqual(self.requests[0][2]["command"], "prune backups")
+            self.assertEqual(self.requests[0][2]["prunedBackups"], ["20210101T000000.000-full"])
+
+    def test_notify_command_and_email(self):
+        with tempfile.TemporaryDirectory() as d:
+            ensure_dir(f"{d}/source")
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            with open(f"{d}/source/a", "w+") as fd: fd.write("a")
+            with open(f"{d}/sendmail", "w+") as fd:
+                fd.write(f'#!/bin/sh\necho "$@" > {d}/sendmail.args\ncat > {d}/mail.txt\n')
+            os.chmod(f"{d}/sendmail", 0o755)
+
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub"
+            dest = f"id=test,type=fs,path={d}/backups,notify-command=sh -c 'cat > {d}/report.json; echo $UBACK_STATUS > {d}/status',notify-email=admin@example.com,sendmail-command={d}/sendmail -t"
+
+            b = check_output([uback, "backup", source, dest]).strip().decode()
+            report = json.loads(read_file(f"{d}/report.json"))
+            self.assertEqual(report["backup"], b)
+            self.assertEqual(report["job"], "backup tar test")
+            self.assertEqual(read_file(f"{d}/status", "r").strip(), "success")
+
+            self.assertEqual(read_file(f"{d}/sendmail.args", "r").strip(), "-t")
+            mail = read_file(f"{d}/mail.txt", "r")
+            self.assertIn("To: admin@example.com\n", mail)
+            self.assertIn(f"Subject: uback: backup tar test success ({b})\n", mail)
+            self.assertIn(f"Backup: {b}\n", mail)
@@ -108,3 +108,120 @@ class PruneTests(unittest.TestCase):
                 {"20210101T000000.000-full.ubkp", "20210102T000000.000-from-20210101T000000.000.ubkp", "20210103T000000.000-full.ubkp", "20210104T000000.000-from-20210103T000000.000.ubkp",
                     "20210105T000000.000-full.ubkp", "20210106T000000.000-from-20210105T000000.000.ubkp", f"{b}.ubkp"})
             self.assertTrue(b.endswith("-full"))
+
+    def test_held_backups(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/backups")
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=1"
+
+            pathlib.Path(f"{d}/backups/20210101T000000.000-full.ubkp").touch()
+            pathlib.Path(f"{d}/backups/20210102T000000.000-from-20210101T000000.000.ubkp").touch()
+            pathlib.Path(f"{d}/backups/20210103T000000.000-full.ubkp").touch()
+            pathlib.Path(f"{d}/backups/20210104T000000.000-full.ubkp").touch()
+
+            self.assertEqual(check_output([uback, "hold", dest, "20210102"]).strip().decode(), "20210102T000000.000-from-20210101T000000.000")
+            check_call([uback, "hold", dest, "20210103T000000.000-full"])
+            self.assertNotEqual(0, run([uback, "hold", dest, "20200101"]).returncode)
+            self.assertEqual(check_output([uback, "list", "backups", dest]).decode().splitlines(), [
+                "20210101T000000.000 (full)",
+                "20210102T000000.000 (base: 20210101T000000.000) [held]",
+                "20210103T000000.000 (full) [held]",
+                "20210104T000000.000 (full)",
+            ])
+
+            check_call([uback, "prune", "backups", dest])
+            self.assertEqual(set(os.listdir(f"{d}/backups")), {
+                "20210101T000000.000-full.ubkp", "20210102T000000.000-from-20210101T000000.000.ubkp", "20210102T000000.000-from-20210101T000000.000.ubkh",
+                "20210103T000000.000-full.ubkp", "20210103T000000.000-full.ubkh",
+                "20210104T000000.000-full.ubkp"})
+
+            check_call([uback, "release", dest, "20210102"])
+            check_call([uback, "release", dest, "20210103"])
+            self.assertNotEqual(0, run([uback, "release", dest, "20210103"]).returncode)
+            check_call([uback, "prune", "backups", dest])
+            self.assertEqual(set(os.listdir(f"{d}/backups")), {"20210104T000000.000-full.ubkp"})
+
+    def test_explain(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/snapshots")
+            os.mkdir(f"{d}/backups")
+            source = f"type=command,command=uback-tar-src,path={d}/source,no-encryption=1,state-file={d}/state.json,snapshots-path={d}/snapshots,@retention-policy=daily=1"
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=1"
+
+            pathlib.Path(f"{d}/snapshots/20210101T000000.000").touch()
+            pathlib.Path(f"{d}/snapshots/20210102T000000.000").touch()
+            pathlib.Path(f"{d}/snapshots/20210103T000000.000").touch()
+            pathlib.Path(f"{d}/backups/20210101T000000.000-full.ubkp").touch()
+            pathlib.Path(f"{d}/backups/20210102T000000.000-full.ubkp").touch()
+            pathlib.Path(f"{d}/backups/20210103T000000.000-from-20210102T000000.000.ubkp").touch()
+            with open(f"{d}/state.json", "w+") as fd: fd.write('{"test":"20210102T000000.000"}')
+
+            self.assertEqual(check_output([uback, "prune", "backups", "--explain", dest]).decode().splitlines(), [
+                "20210103T000000.000-from-20210102T000000.000\tkeep\t1d=1",
+                "20210102T000000.000-full\tkeep\tdependency of 20210103T000000.000-from-20210102T000000.000",
+                "20210101T000000.000-full\tprune\tnot retained by any retention policy",
+            ])
+            self.assertEqual(check_output([uback, "prune", "snapshots", "--explain", source]).decode().splitlines(), [
+                "archive\t20210103T000000.000\tkeep\t1d=1",
+                "archive\t20210102T000000.000\tkeep\tlast backup on destination test",
+                "archive\t20210101T000000.000\tprune\tnot retained by any retention policy",
+            ])
+
+            self.assertEqual(len(os.listdir(f"{d}/snapshots")), 3)
+            self.assertEqual(len(os.listdir(f"{d}/backups")), 3)
+
+    def test_max_size(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/backups")
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=10,max-size=3K"
+
+            for f in ("20210101T000000.000-full.ubkp", "20210102T000000.000-from-20210101T000000.000.ubkp",
+                    "20210103T000000.000-full.ubkp", "20210104T000000.000-full.ubkp", "20210105T000000.000-from-20210104T000000.000.ubkp"):
+                with open(f"{d}/backups/{f}", "wb") as fd: fd.write(b"\0" * 1024)
+
+            self.assertEqual(check_output([uback, "prune", "backups", "--explain", dest]).decode().splitlines(), [
+                "20210105T000000.000-from-20210104T000000.000\tkeep\t1d=10",
+                "20210104T000000.000-full\tkeep\t1d=10, dependency of 20210105T000000.000-from-20210104T000000.000",
+                "20210103T000000.000-full\tkeep\t1d=10",
+                "20210102T000000.000-from-20210101T000000.000\tprune\toldest chain, pruned to fit MaxSize",
+                "20210101T000000.000-full\tprune\toldest chain, pruned to fit MaxSize",
+            ])
+
+            check_call([uback, "prune", "backups", dest])
+            self.assertEqual(set(os.listdir(f"{d}/backups")), {
+                "20210103T000000.000-full.ubkp", "20210104T000000.000-full.ubkp", "20210105T000000.000-from-20210104T000000.000.ubkp"})
+
+    def test_max_size_unsupported(self):
+        # The error is reported when parsing options, not once backups have been listed
+        res = run([uback, "prune", "backups", "id=test,type=command,command=true,@retention-policy=daily=10,max-size=3K"], capture_output=True)
+        self.assertNotEqual(res.returncode, 0)
+        self.assertIn(b"MaxSize is not supported by this destination", res.stderr)
+
+    def test_max_size_invalid(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/backups")
+            pathlib.Path(f"{d}/backups/20210101T000000.000-full.ubkp").touch()
+            for size in ("0", "0K", "-1G", "99999999999T"):
+                with self.subTest(size=size):
+                    res = run([uback, "prune", "backups", f"id=test,type=fs,path={d}/backups,@retention-policy=daily=10,max-size={size}"], capture_output=True)
+                    self.assertNotEqual(res.returncode, 0)
+                    self.assertEqual(os.listdir(f"{d}/backups"), ["20210101T000000.000-full.ubkp"])
+
+    def test_chain_costs(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/backups")
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=last=1"
+
+            for f in ("20210101T000000.000-full.ubkp", "20210102T000000.000-from-20210101T000000.000.ubkp",
+                    "20210103T000000.000-from-20210102T000000.000.ubkp"):
+                with open(f"{d}/backups/{f}", "wb") as fd: fd.write(b"\0" * 1024)
+
+            # Chain costs are logged before anything is removed, without polluting the list of pruned backups
+            for opts in (["--dry-run"], []):
+                res = run([uback, "prune", "backups", *opts, dest], capture_output=True, check=True)
+                self.assertEqual(res.stdout, b"")
+                self.assertIn(b'msg="chain cost" backups=3 chain=20210101T000000.000-full dependencies=2 dependencies-size=2.0K size=3.0K', res.stderr)
+            self.assertEqual(len(os.listdir(f"{d}/backups")), 3)
+
+            res = run([uback, "prune", "backups", "--no-chain-costs", dest], capture_output=True, check=True)
+            self.assertNotIn(b"chain cost", res.stderr)
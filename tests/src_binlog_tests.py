@@ -0,0 +1,162 @@
+from .common import *
+
+# Stub emulating "FLUSH BINARY LOGS; SHOW BINARY LOGS": create a new binary log and list all of them
+MARIADB_STUB = """#!/bin/bash
+set -e
+cd "$BINLOG_STUB_DIR"
+last=$(ls | sed 's/.*\\.//' | sort -n | tail -n 1)
+touch "$(printf "mysql-bin.%06d" $((10#${last:-0}+1)))"
+for f in $(ls | sort -t. -k2,2n) ; do printf "%s\\t%s\\n" "$f" "$(stat -c %s "$f")" ; done
+"""
+
+MARIADB_BINLOG_STUB = """#!/bin/bash
+echo "$@"
+"""
+
+class SrcBinlogTests(unittest.TestCase):
+    def test_binlog_source(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/binlogs")
+            os.mkdir(f"{d}/bin")
+            env = stub_env(BINLOG_STUB_DIR=f"{d}/binlogs")
+            write_stub(f"{d}/bin", "mariadb", MARIADB_STUB)
+            write_stub(f"{d}/bin", "mariadb-binlog", MARIADB_BINLOG_STUB)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+
+            source = f"type=binlog,binlog-path={d}/binlogs,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,mariadb-command={d}/bin/mariadb"
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=3,key-file={d}/backup.key"
+
+            with open(f"{d}/binlogs/mysql-bin.000001", "w+") as fd: fd.write("log1")
+            b1 = check_output([uback, "backup", "-n", source, dest], env=env).strip().decode()
+            self.assertTrue(b1.endswith("-full"))
+            time.sleep(0.01)
+
+            with open(f"{d}/binlogs/mysql-bin.000002", "w+") as fd: fd.write("log2")
+            b2 = check_output([uback, "backup", "-n", source, dest], env=env).strip().decode()
+            self.assertFalse(b2.endswith("-full"))
+            s2 = b2.split("-")[0]
+
+            # Incremental backups only contain new binary logs
+            os.mkdir(f"{d}/restore")
+            run(["tar", "-C", f"{d}/restore", "-x"], input=check_output([uback, "container", "extract", "-k", f"{d}/backup.key"], input=read_file(f"{d}/backups/{b2}.ubkp")), check=True)
+            self.assertEqual(set(os.listdir(f"{d}/restore")), {"mysql-bin.000002"})
+            shutil.rmtree(f"{d}/restore")
+
+            check_call([uback, "restore", "-d", f"{d}/restore", "-o", "stop-datetime=2021-01-01 00:00:00", dest])
+            self.assertEqual(set(os.listdir(f"{d}/restore/{s2}")), {"mysql-bin.000001", "mysql-bin.000002", "binlog-replay.sh", "binlog-replay.conf"})
+            self.assertEqual(b"log1", read_file(f"{d}/restore/{s2}/mysql-bin.000001"))
+            self.assertEqual(b"log2", read_file(f"{d}/restore/{s2}/mysql-bin.000002"))
+
+            os.mkdir(f"{d}/datadir")
+            with open(f"{d}/datadir/xtrabackup_binlog_info", "w+") as fd: fd.write("mysql-bin.000002\t4\t0-1-2\n")
+            out = check_output([f"{d}/restore/{s2}/binlog-replay.sh", f"{d}/datadir"], env=stub_env(f"{d}/bin")).decode()
+            self.assertEqual(out.strip(), f"--start-position=4 --stop-datetime=2021-01-01 00:00:00 {d}/restore/{s2}/mysql-bin.000002")
+
+    def test_binlog_source_rollover(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/binlogs")
+            os.mkdir(f"{d}/bin")
+            env = stub_env(BINLOG_STUB_DIR=f"{d}/binlogs")
+            write_stub(f"{d}/bin", "mariadb", MARIADB_STUB)
+            write_stub(f"{d}/bin", "mariadb-binlog", MARIADB_BINLOG_STUB)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+
+            source = f"type=binlog,binlog-path={d}/binlogs,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,mariadb-command={d}/bin/mariadb"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+
+            with open(f"{d}/binlogs/mysql-bin.999999", "w+") as fd: fd.write("log1")
+            check_call([uback, "backup", "-n", source, dest], env=env)
+            time.sleep(0.01)
+
+            # mysql-bin.1000000 sorts before mysql-bin.999999 as a string
+            with open(f"{d}/binlogs/mysql-bin.1000000", "w+") as fd: fd.write("log2")
+            b2 = check_output([uback, "backup", "-n", source, dest], env=env).strip().decode()
+            self.assertFalse(b2.endswith("-full"))
+            s2 = b2.split("-")[0]
+
+            os.mkdir(f"{d}/restore")
+            run(["tar", "-C", f"{d}/restore", "-x"], input=check_output([uback, "container", "extract", "-k", f"{d}/backup.key"], input=read_file(f"{d}/backups/{b2}.ubkp")), check=True)
+            self.assertEqual(set(os.listdir(f"{d}/restore")), {"mysql-bin.1000000"})
+            shutil.rmtree(f"{d}/restore")
+
+            check_call([uback, "restore", "-d", f"{d}/restore", dest])
+            os.mkdir(f"{d}/datadir")
+            with open(f"{d}/datadir/xtrabackup_binlog_info", "w+") as fd: fd.write("mysql-bin.999999\t4\t0-1-2\n")
+            out = check_output([f"{d}/restore/{s2}/binlog-replay.sh", f"{d}/datadir"], env=stub_env(f"{d}/bin")).decode()
+            self.assertEqual(out.strip(), f"--start-position=4 {d}/restore/{s2}/mysql-bin.999999 {d}/restore/{s2}/mysql-bin.1000000")
+
+    def test_binlog_source_purged(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/binlogs")
+            os.mkdir(f"{d}/bin")
+            env = stub_env(BINLOG_STUB_DIR=f"{d}/binlogs")
+            write_stub(f"{d}/bin", "mariadb", MARIADB_STUB)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+
+            source = f"type=binlog,binlog-path={d}/binlogs,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,mariadb-command={d}/bin/mariadb"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+
+            with open(f"{d}/binlogs/mysql-bin.000001", "w+") as fd: fd.write("log1")
+            check_call([uback, "backup", "-n", source, dest], env=env)
+            time.sleep(0.01)
+
+            # mysql-bin.000002 is purged by the server before being archived
+            os.unlink(f"{d}/binlogs/mysql-bin.000001")
+            os.unlink(f"{d}/binlogs/mysql-bin.000002")
+            with open(f"{d}/binlogs/mysql-bin.000003", "w+") as fd: fd.write("log3")
+            res = run([uback, "backup", "-n", source, dest], env=env, capture_output=True)
+            self.assertEqual(res.returncode, 0)
+            self.assertTrue(res.stdout.strip().decode().endswith("-full"))
+            self.assertIn(b"binary logs between mysql-bin.000001 and mysql-bin.000003 are missing, forcing full backup", res.stderr)
+
+    def test_binlog_source_stop_gtid(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/binlogs")
+            os.mkdir(f"{d}/bin")
+            env = stub_env(BINLOG_STUB_DIR=f"{d}/binlogs")
+            write_stub(f"{d}/bin", "mariadb", MARIADB_STUB)
+            write_stub(f"{d}/bin", "mariadb-binlog", MARIADB_BINLOG_STUB)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+
+            source = f"type=binlog,binlog-path={d}/binlogs,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,mariadb-command={d}/bin/mariadb"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+
+            with open(f"{d}/binlogs/mysql-bin.000001", "w+") as fd: fd.write("log1")
+            b1 = check_output([uback, "backup", "-n", source, dest], env=env).strip().decode()
+            s1 = b1.split("-")[0]
+
+            # A GTID stop position cannot be mixed with a file offset or be malformed
+            self.assertNotEqual(0, run([uback, "restore", "-d", f"{d}/restore", "-o", "stop-gtid=0-1-5,stop-position=4", dest], capture_output=True).returncode)
+            self.assertNotEqual(0, run([uback, "restore", "-d", f"{d}/restore", "-o", "stop-gtid=mysql-bin.000001", dest], capture_output=True).returncode)
+            self.assertNotEqual(0, run([uback, "restore", "-d", f"{d}/restore", "-o", "stop-position=0-1-5", dest], capture_output=True).returncode)
+
+            check_call([uback, "restore", "-d", f"{d}/restore", "-o", "stop-gtid=0-1-5", dest])
+            os.mkdir(f"{d}/datadir")
+            with open(f"{d}/datadir/xtrabackup_binlog_info", "w+") as fd: fd.write("mysql-bin.000001\t4\t0-1-2\n")
+            out = check_output([f"{d}/restore/{s1}/binlog-replay.sh", f"{d}/datadir"], env=stub_env(f"{d}/bin")).decode()
+            self.assertEqual(out.strip(), f"--start-position=0-1-2 --stop-position=0-1-5 {d}/restore/{s1}/mysql-bin.000001")
+
+            # Without a recorded GTID, the replay cannot start
+            with open(f"{d}/datadir/xtrabackup_binlog_info", "w+") as fd: fd.write("mysql-bin.000001\t4\n")
+            res = run([f"{d}/restore/{s1}/binlog-replay.sh", f"{d}/datadir"], env=stub_env(f"{d}/bin"), capture_output=True)
+            self.assertNotEqual(0, res.returncode)
+
+    def test_binlog_source_timeout(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/binlogs")
+            os.mkdir(f"{d}/bin")
+            env = stub_env(BINLOG_STUB_DIR=f"{d}/binlogs")
+            write_stub(f"{d}/bin", "mariadb", MARIADB_STUB)
+            write_stub(f"{d}/bin", "slow-dest", '#!/bin/sh\n[ "$2" = send-backup ] && head -c 1000 > /dev/null && exec sleep 60\nexit 0\n')
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+
+            source = f"type=binlog,binlog-path={d}/binlogs,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,mariadb-command={d}/bin/mariadb"
+            dest = f"id=test,type=command,command={d}/bin/slow-dest,timeout=1s"
+
+            with open(f"{d}/binlogs/mysql-bin.000001", "wb+") as fd: fd.write(os.urandom(4 << 20))
+            res = run([uback, "backup", source, dest], env=env, capture_output=True)
+            self.assertNotEqual(res.returncode, 0)
+            self.assertIn(b"destination timeout (1s) exceeded", res.stderr)
+
+            # The aborted backup must not leave a bookmark, even a temporary one
+            self.assertEqual(os.listdir(f"{d}/snapshots"), [])
@@ -7,3 +7,44 @@ class SrcCommandTests(unittest.TestCase, SrcBaseTests):
             source = f"type=command,command=uback-tar-src,path={d}/source,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,@extra-args=--exclude=./c,@extra-args=--exclude=./d"
             dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=3,key-file={d}/backup.key"
             self._test_src(d, source, dest, test_ignore=True, test_delete=False)
+
+    def _write_slow_source(self, d):
+        # Never finishes the backup
+        with open(f"{d}/slow-src", "w+") as fd:
+            fd.write("#!/bin/sh\n")
+            fd.write('case "$2" in\n')
+            fd.write("  type) echo command:slow ;;\n")
+            fd.write("  create-backup) echo 20210102T030405.000-full; head -c 1000 /dev/zero; exec sleep 60 ;;\n")
+            fd.write("esac\n")
+        os.chmod(f"{d}/slow-src", 0o755)
+
+    def test_command_source_timeout(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._write_slow_source(d)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            source = f"type=command,command={d}/slow-src,key-file={d}/backup.pub,timeout=1s"
+            dest = f"id=test,type=fs,path={d}/backups"
+
+            start = time.time()
+            p = run([uback, "backup", source, dest], stdout=subprocess.PIPE, stderr=subprocess.PIPE)
+            self.assertNotEqual(p.returncode, 0)
+            self.assertIn(b"source timeout (1s) exceeded", p.stderr)
+            self.assertLess(time.time() - start, 30)
+            self.assertEqual(os.listdir(f"{d}/backups"), [])
+
+    def test_command_source_interrupt(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._write_slow_source(d)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            source = f"type=command,command={d}/slow-src,key-file={d}/backup.pub"
+            dest = f"id=test,type=fs,path={d}/backups"
+
+            start = time.time()
+            p = subprocess.Popen([uback, "backup", source, dest], stdout=subprocess.PIPE, stderr=subprocess.PIPE)
+            time.sleep(1)
+            p.send_signal(signal.SIGINT)
+            _, stderr = p.communicate(timeout=30)
+            self.assertNotEqual(p.returncode, 0)
+            self.assertIn(b"interrupted by interrupt", stderr)
+            self.assertLess(time.time() - start, 30)
+            self.assertEqual(os.listdir(f"{d}/backups"), [])
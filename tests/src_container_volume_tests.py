@@ -0,0 +1,85 @@
+from .common import *
+
+# Stub emulating podman and docker: volumes are directories of $VOLUMES_STUB, other commands are logged
+CONTAINER_STUB = """#!/bin/bash
+set -e
+echo "$@" >> "$VOLUMES_STUB/log"
+[ "$1" = "pause" ] && [ -n "$PAUSE_STUB_FAIL" ] && exit 1
+case "$1 $2" in
+  "volume export") tar -C "$VOLUMES_STUB/$3" -cf - . ;;
+  "volume import") tar -C "$VOLUMES_STUB/$3" -xf - ;;
+  "volume inspect") test -d "$VOLUMES_STUB/$3" ;;
+  "volume create") mkdir "$VOLUMES_STUB/$3" ;;
+  "run --rm")
+    shift 2
+    [ "$1" = "-i" ] && shift
+    volume="${2%%:*}"
+    shift 3
+    "${@/#\\/volume/$VOLUMES_STUB/$volume}"
+    ;;
+esac
+"""
+
+class SrcContainerVolumeTests(unittest.TestCase, SrcBaseTests):
+    def _setup(self, d):
+        os.mkdir(f"{d}/bin")
+        os.mkdir(f"{d}/volumes")
+        os.environ["VOLUMES_STUB"] = f"{d}/volumes"
+        with open(f"{d}/bin/podman", "w+") as fd: fd.write(CONTAINER_STUB)
+        os.chmod(f"{d}/bin/podman", 0o755)
+        check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+        for p in ("data/a", "data/sub/b", "config/c"):
+            os.makedirs(os.path.dirname(f"{d}/volumes/{p}"), exist_ok=True)
+            with open(f"{d}/volumes/{p}", "w+") as fd: fd.write(p)
+
+    def _test(self, d, engine_opts):
+        self._setup(d)
+        source = f"type=container-volume,{engine_opts},@volume=data,@volume=config,container=app,pause=true,@exec-before=sync,@exec-after=true,key-file={d}/backup.pub"
+        dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+        b = check_output([uback, "backup", source, dest]).strip().decode()
+        s = b.split("-")[0]
+
+        log = read_file(f"{d}/volumes/log", "r").splitlines()
+        self.assertEqual(log[0], "exec app sync")
+        self.assertEqual(log[1], "pause app")
+        self.assertEqual(log[-2:], ["unpause app", "exec app true"])
+
+        # Restore as a directory
+        os.mkdir(f"{d}/restore")
+        check_call([uback, "restore", "-d", f"{d}/restore", dest])
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}")), {"data", "config"})
+        self.assertEqual(b"data/sub/b", read_file(f"{d}/restore/{s}/data/sub/b"))
+
+        # Restore into existing and new volumes
+        os.unlink(f"{d}/volumes/data/a")
+        shutil.rmtree(f"{d}/volumes/config")
+        check_call([uback, "restore", "-o", f"{engine_opts},restore-to-volume=true", dest])
+        self.assertEqual(b"data/a", read_file(f"{d}/volumes/data/a"))
+        self.assertEqual(b"config/c", read_file(f"{d}/volumes/config/c"))
+
+        # Restore into a renamed volume
+        source = f"type=container-volume,{engine_opts},@volume=data,key-file={d}/backup.pub"
+        check_call([uback, "backup", source, dest])
+        check_call([uback, "restore", "-o", f"{engine_opts},restore-to-volume=true,volume-name=data-copy", dest])
+        self.assertEqual(b"data/sub/b", read_file(f"{d}/volumes/data-copy/sub/b"))
+
+    def test_container_volume_source_podman(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._test(d, f"command={d}/bin/podman")
+
+    def test_container_volume_source_docker(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._test(d, f"use-podman=false,command={d}/bin/podman")
+            self.assertIn("run --rm -v data:/volume:ro docker.io/library/alpine tar -C /volume -cf - .", read_file(f"{d}/volumes/log", "r"))
+
+    def test_container_volume_source_pause_failure(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._setup(d)
+            source = f"type=container-volume,command={d}/bin/podman,@volume=data,container=app,pause=true,@exec-before=sync,@exec-after=true,key-file={d}/backup.pub"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+            res = run([uback, "backup", source, dest], env=stub_env(PAUSE_STUB_FAIL="1"))
+            self.assertNotEqual(res.returncode, 0)
+
+            # @ExecAfter still undoes @ExecBefore
+            log = read_file(f"{d}/volumes/log", "r").splitlines()
+            self.assertEqual(log, ["exec app sync", "pause app", "exec app true"])
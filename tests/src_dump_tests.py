@@ -0,0 +1,72 @@
+from .common import *
+
+# Stubs emulating dump tools: a "database" is a plain file
+PG_DUMP_STUB = """#!/bin/bash
+set -e
+for db in "$@" ; do :; done
+cat "$DUMP_STUB_DATA/$db"
+"""
+
+SQLITE3_STUB = """#!/bin/bash
+set -e
+dst="${2#.backup \\'}"
+cp "$1" "${dst%\\'}"
+"""
+
+class SrcDumpTests(unittest.TestCase):
+    def test_dump_source_single(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/data")
+            env = stub_env(DUMP_STUB_DATA=f"{d}/data")
+            write_stub(d, "pg_dump", PG_DUMP_STUB)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+
+            source = f"type=dump,engine=postgres,@database=db1,key-file={d}/backup.pub,command={d}/pg_dump"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+
+            with open(f"{d}/data/db1", "w+") as fd: fd.write("db1v1")
+            b1 = check_output([uback, "backup", source, dest], env=env).strip().decode()
+            self.assertTrue(b1.endswith("-full"))
+            time.sleep(0.01)
+
+            with open(f"{d}/data/db1", "w+") as fd: fd.write("db1v2")
+            b2 = check_output([uback, "backup", source, dest], env=env).strip().decode()
+            self.assertTrue(b2.endswith("-full"))
+            s2 = b2.split("-")[0]
+
+            os.mkdir(f"{d}/restore")
+            check_call([uback, "restore", "-d", f"{d}/restore", dest])
+            self.assertEqual(os.listdir(f"{d}/restore"), [f"{s2}.dump"])
+            self.assertEqual(b"db1v2", read_file(f"{d}/restore/{s2}.dump"))
+
+            # Multiple databases require the tar option
+            source = f"type=dump,engine=postgres,@database=db1,@database=db2,key-file={d}/backup.pub,command={d}/pg_dump"
+            self.assertNotEqual(0, run([uback, "backup", source, dest], env=env).returncode)
+
+    def test_dump_source_tar(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/data")
+            write_stub(d, "sqlite3", SQLITE3_STUB)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+
+            source = f"type=dump,engine=sqlite,tar=true,@database={d}/data/a.db,@database={d}/data/b.db,key-file={d}/backup.pub,command={d}/sqlite3"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+
+            with open(f"{d}/data/a.db", "w+") as fd: fd.write("a")
+            with open(f"{d}/data/b.db", "w+") as fd: fd.write("b")
+            b = check_output([uback, "backup", source, dest]).strip().decode()
+            s = b.split("-")[0]
+
+            os.mkdir(f"{d}/restore")
+            check_call([uback, "restore", "-d", f"{d}/restore", dest])
+            self.assertEqual(set(os.listdir(f"{d}/restore/{s}")), {"a.db", "b.db"})
+            self.assertEqual(b"a", read_file(f"{d}/restore/{s}/a.db"))
+            self.assertEqual(b"b", read_file(f"{d}/restore/{s}/b.db"))
+
+            # Dumps with the same name would overwrite each other
+            os.mkdir(f"{d}/data/other")
+            with open(f"{d}/data/other/a.db", "w+") as fd: fd.write("other a")
+            source = f"type=dump,engine=sqlite,tar=true,@database={d}/data/a.db,@database={d}/data/other/a.db,key-file={d}/backup.pub,command={d}/sqlite3"
+            p = run([uback, "backup", source, dest], stderr=subprocess.PIPE)
+            self.assertNotEqual(0, p.returncode)
+            self.assertIn(b"would both be dumped to a.db", p.stderr)
@@ -0,0 +1,61 @@
+from .common import *
+
+# Stubs emulating LVM: a snapshot "device" is a copy of the origin, mounting it copies it onto the mount point
+LVCREATE_STUB = """#!/bin/bash
+set -e
+mkdir -p "$LVM_STUB_DEVICES/${3%/*}"
+cp -a "$LVM_STUB_ORIGIN" "$LVM_STUB_DEVICES/${3%/*}/$2"
+"""
+
+MOUNT_STUB = """#!/bin/bash
+set -e
+cp -a "$1/." "$2"
+"""
+
+UMOUNT_STUB = """#!/bin/bash
+set -e
+find "$1" -mindepth 1 -delete
+"""
+
+LVREMOVE_STUB = """#!/bin/bash
+set -e
+rm -rf "$LVM_STUB_DEVICES/$1"
+"""
+
+class SrcLvmTests(unittest.TestCase, SrcBaseTests):
+    def _setup_stubs(self, d, origin):
+        os.mkdir(f"{d}/bin")
+        os.mkdir(f"{d}/devices")
+        os.environ["LVM_STUB_DEVICES"] = f"{d}/devices"
+        os.environ["LVM_STUB_ORIGIN"] = origin
+        for name, content in (("lvcreate", LVCREATE_STUB), ("mount", MOUNT_STUB), ("umount", UMOUNT_STUB), ("lvremove", LVREMOVE_STUB)):
+            with open(f"{d}/bin/{name}", "w+") as fd: fd.write(content)
+            os.chmod(f"{d}/bin/{name}", 0o755)
+        return f"volume=vg/lv,devices-path={d}/devices,snapshot-command={d}/bin/lvcreate,mount-command={d}/bin/mount,unmount-command={d}/bin/umount,remove-command={d}/bin/lvremove"
+
+    def test_lvm_source_tar(self):
+        with tempfile.TemporaryDirectory() as d:
+            stubs = self._setup_stubs(d, f"{d}/source")
+            source = f"type=lvm,{stubs},mount-path={d}/mnt,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly"
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=3,key-file={d}/backup.key"
+            b1, b2, b3, _ = self._test_src(d, source, dest, test_delete=False)
+            self.assertFalse(b2.endswith("-full"))
+            self.assertEqual(os.listdir(f"{d}/devices/vg"), [])
+            self.assertEqual(os.listdir(f"{d}/mnt"), [])
+
+    def test_lvm_source_raw(self):
+        with tempfile.TemporaryDirectory() as d:
+            with open(f"{d}/origin", "w+") as fd: fd.write("block device content")
+            stubs = self._setup_stubs(d, f"{d}/origin")
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            source = f"type=lvm,mode=raw,{stubs},key-file={d}/backup.pub"
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=3,key-file={d}/backup.key"
+            os.mkdir(f"{d}/restore")
+
+            b = check_output([uback, "backup", source, dest]).strip().decode()
+            s = b.split("-")[0]
+            self.assertTrue(b.endswith("-full"))
+            self.assertEqual(os.listdir(f"{d}/devices/vg"), [])
+
+            check_call([uback, "restore", "-d", f"{d}/restore", dest])
+            self.assertEqual(b"block device content", read_file(f"{d}/restore/{s}.img"))
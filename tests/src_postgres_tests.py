@@ -0,0 +1,84 @@
+from .common import *
+
+# Stubs emulating pg_basebackup/pg_combinebackup: the "cluster" is a plain directory, incremental
+# backups contain only files modified since the manifest given by --incremental
+PG_BASEBACKUP_STUB = """#!/bin/bash
+set -e
+if [ "$1" = "--version" ] ; then
+    echo "pg_basebackup (PostgreSQL) $PG_STUB_VERSION"
+    exit 0
+fi
+incremental=
+for arg in "$@" ; do
+    case "$arg" in
+        --incremental=*) incremental="${arg#--incremental=}" ;;
+    esac
+done
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+if [ -n "$incremental" ] ; then
+    find "$PG_STUB_DATA" -type f -newer "$incremental" -exec cp {} "$tmp" \;
+    echo incremental > "$tmp/backup_manifest"
+else
+    cp -a "$PG_STUB_DATA/." "$tmp"
+    echo full > "$tmp/backup_manifest"
+fi
+tar -C "$tmp" -c .
+"""
+
+PG_COMBINEBACKUP_STUB = """#!/bin/bash
+set -e
+out=
+dirs=()
+for arg in "$@" ; do
+    case "$arg" in
+        --output=*) out="${arg#--output=}" ;;
+        *) dirs+=("$arg") ;;
+    esac
+done
+mkdir -p "$out"
+for d in "${dirs[@]}" ; do
+    cp -a "$d/." "$out"
+done
+"""
+
+class SrcPostgresTests(unittest.TestCase):
+    def _test_postgres(self, version, expect_incremental):
+        with tempfile.TemporaryDirectory() as d:
+            os.mkdir(f"{d}/data")
+            env = stub_env(PG_STUB_DATA=f"{d}/data", PG_STUB_VERSION=version)
+            write_stub(d, "pg_basebackup", PG_BASEBACKUP_STUB)
+            write_stub(d, "pg_combinebackup", PG_COMBINEBACKUP_STUB)
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+
+            source = f"type=postgres,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,command={d}/pg_basebackup"
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=3,key-file={d}/backup.key"
+
+            with open(f"{d}/data/a", "w+") as fd: fd.write("av1")
+            with open(f"{d}/data/b", "w+") as fd: fd.write("bv1")
+            b1 = check_output([uback, "backup", "-n", source, dest], env=env).strip().decode()
+            self.assertTrue(b1.endswith("-full"))
+            self.assertEqual(os.listdir(f"{d}/snapshots"), [b1.split("-")[0]])
+            time.sleep(0.01)
+
+            with open(f"{d}/data/b", "w+") as fd: fd.write("bv2")
+            b2 = check_output([uback, "backup", "-n", source, dest], env=env).strip().decode()
+            self.assertEqual(not b2.endswith("-full"), expect_incremental)
+            time.sleep(0.01)
+
+            with open(f"{d}/data/c", "w+") as fd: fd.write("cv1")
+            b3 = check_output([uback, "backup", "-n", source, dest], env=env).strip().decode()
+            s3 = b3.split("-")[0]
+            self.assertEqual(not b3.endswith("-full"), expect_incremental)
+
+            check_call([uback, "restore", "-d", f"{d}/restore", "-o", f"combine-command={d}/pg_combinebackup", dest], env=env)
+            self.assertEqual(set(os.listdir(f"{d}/restore")), {s3})
+            self.assertEqual(b"av1", read_file(f"{d}/restore/{s3}/a"))
+            self.assertEqual(b"bv2", read_file(f"{d}/restore/{s3}/b"))
+            self.assertEqual(b"cv1", read_file(f"{d}/restore/{s3}/c"))
+
+    def test_postgres_source(self):
+        self._test_postgres("17.2", True)
+
+    def test_postgres_source_no_incremental(self):
+        self._test_postgres("16.4 (Debian 16.4-1.pgdg120+1)", False)
@@ -1,11 +1,13 @@
 from .common import *
 
+import hashlib
+
 class SrcTarTests(unittest.TestCase, SrcBaseTests):
     def test_tar_source(self):
         with tempfile.TemporaryDirectory() as d:
             source = f"type=tar,path={d}/source,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,@command=tar,@command=--exclude=./c,@command=--exclude=./d"
             dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=3,key-file={d}/backup.key"
-            b1, b2, b3, _ = self._test_src(d, source, dest, test_ignore=True, test_delete=False)
+            b1, b2, b3, _ = self._test_src(d, source, dest, test_ignore=True, test_delete=True)
 
             # Check that incremental backups are actually incremental
             run(["tar", "-C", f"{d}/restore", "-x"], input=check_output([uback, "container", "extract", "-k", f"{d}/backup.key"], input=read_file(f"{d}/backups/{b2}.ubkp")), check=True)
@@ -17,3 +19,363 @@ class SrcTarTests(unittest.TestCase, SrcBaseTests):
             run(["tar", "-C", f"{d}/restore", "-x"], input=check_output([uback, "container", "extract", "-k", f"{d}/backup.key"], input=read_file(f"{d}/backups/{b3}.ubkp")), check=True)
             self.assertEqual(set(os.listdir(f"{d}/restore/")), {"a"})
             self.assertEqual(b"av2", read_file(f"{d}/restore/a"))
+
+    def test_native_tar_source(self):
+        with tempfile.TemporaryDirectory() as d:
+            source = f"type=tar,native=true,path={d}/source,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly"
+            dest = f"id=test,type=fs,path={d}/backups,@retention-policy=daily=3,key-file={d}/backup.key"
+            b1, b2, b3, b4 = self._test_src(d, source, dest, test_delete=True)
+
+            # Check that incremental backups are actually incremental
+            run(["tar", "-C", f"{d}/restore", "-x"], input=check_output([uback, "container", "extract", "-k", f"{d}/backup.key"], input=read_file(f"{d}/backups/{b3}.ubkp")), check=True)
+            self.assertEqual(set(os.listdir(f"{d}/restore/")), {"a"})
+            self.assertEqual(b"av2", read_file(f"{d}/restore/a"))
+            shutil.rmtree(f"{d}/restore")
+            os.mkdir(f"{d}/restore")
+
+            # Check links, subdirectories and modes
+            os.mkdir(f"{d}/source/sub")
+            with open(f"{d}/source/sub/c", "w+") as fd: fd.write("c")
+            os.chmod(f"{d}/source/sub/c", 0o640)
+            os.link(f"{d}/source/sub/c", f"{d}/source/hardlink")
+            os.symlink("sub/c", f"{d}/source/symlink")
+            b5 = check_output([uback, "backup", source, dest]).strip().decode()
+            s5 = b5.split("-")[0]
+            check_call([uback, "restore", "-d", f"{d}/restore", dest])
+            self.assertEqual(set(os.listdir(f"{d}/restore/{s5}")), {"a", "sub", "hardlink", "symlink"})
+            self.assertEqual(b"c", read_file(f"{d}/restore/{s5}/sub/c"))
+            self.assertEqual(0o640, os.stat(f"{d}/restore/{s5}/sub/c").st_mode & 0o777)
+            self.assertEqual(os.stat(f"{d}/restore/{s5}/sub/c").st_ino, os.stat(f"{d}/restore/{s5}/hardlink").st_ino)
+            self.assertEqual("sub/c", os.readlink(f"{d}/restore/{s5}/symlink"))
+            self._cleanup_restore(d)
+            time.sleep(0.01)
+
+            # Check deletion of a directory
+            shutil.rmtree(f"{d}/source/sub")
+            b6 = check_output([uback, "backup", source, dest]).strip().decode()
+            s6 = b6.split("-")[0]
+            self.assertFalse(b6.endswith("-full"))
+            check_call([uback, "restore", "-d", f"{d}/restore", dest])
+            self.assertEqual(set(os.listdir(f"{d}/restore/{s6}")), {"a", "hardlink", "symlink"})
+            self.assertEqual(b"c", read_file(f"{d}/restore/{s6}/hardlink"))
+
+    def test_native_tar_source_replaced_directory(self):
+        with tempfile.TemporaryDirectory() as d:
+            source = f"type=tar,native=true,path={d}/source,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            for p in ("source/a/x", "source/b/x", "victim/x"):
+                os.makedirs(os.path.dirname(f"{d}/{p}"), exist_ok=True)
+                with open(f"{d}/{p}", "w+") as fd: fd.write(p)
+            check_call([uback, "backup", source, dest])
+            time.sleep(0.01)
+
+            # Deletions must neither follow a directory replaced by a symbolic link, nor fail on a
+            # directory replaced by a file
+            shutil.rmtree(f"{d}/source/a")
+            os.symlink(f"{d}/victim", f"{d}/source/a")
+            shutil.rmtree(f"{d}/source/b")
+            with open(f"{d}/source/b", "w+") as fd: fd.write("b")
+            b = check_output([uback, "backup", source, dest]).strip().decode()
+            s = b.split("-")[0]
+            self.assertFalse(b.endswith("-full"))
+
+            os.mkdir(f"{d}/restore")
+            check_call([uback, "restore", "-d", f"{d}/restore", dest])
+            self.assertEqual(set(os.listdir(f"{d}/restore/{s}")), {"a", "b"})
+            self.assertEqual(f"{d}/victim", os.readlink(f"{d}/restore/{s}/a"))
+            self.assertEqual(b"b", read_file(f"{d}/restore/{s}/b"))
+            self.assertEqual(b"victim/x", read_file(f"{d}/victim/x"))
+
+    def test_tar_restore_symlinked_parent(self):
+        import tarfile, io
+        with tempfile.TemporaryDirectory() as d:
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            os.mkdir(f"{d}/backups")
+            os.mkdir(f"{d}/victim")
+            with open(f"{d}/victim/x", "w+") as fd: fd.write("x")
+
+            # Entries written through a symbolic link must not escape the restoration directory
+            buf = io.BytesIO()
+            with tarfile.open(fileobj=buf, mode="w", format=tarfile.PAX_FORMAT) as tf:
+                link = tarfile.TarInfo("a")
+                link.type = tarfile.SYMTYPE
+                link.linkname = f"{d}/victim"
+                tf.addfile(link)
+                f = tarfile.TarInfo("a/y")
+                f.size = 1
+                tf.addfile(f, io.BytesIO(b"y"))
+                hardlink = tarfile.TarInfo("z")
+                hardlink.type = tarfile.LNKTYPE
+                hardlink.linkname = "a/x"
+                tf.addfile(hardlink)
+            with open(f"{d}/backups/20210101T000000.000-full.ubkp", "wb") as fd:
+                run([uback, "container", "create", "-k", f"{d}/backup.pub", "tar"], input=buf.getvalue(), stdout=fd, check=True)
+
+            os.mkdir(f"{d}/restore")
+            self.assertNotEqual(0, run([uback, "restore", "-d", f"{d}/restore", dest]).returncode)
+            self.assertEqual({"x"}, set(os.listdir(f"{d}/victim")))
+            self.assertEqual(b"y", read_file(f"{d}/restore/20210101T000000.000/a/y"))
+            self.assertFalse(os.path.exists(f"{d}/restore/20210101T000000.000/z"))
+
+    def _test_filters(self, d, source):
+        dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+        check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+        for p in ("src/app/node_modules/x", "src/app/cache/y", "src/app/secret/z", "src/app/tmp/t", "src/app/main.c", "src/other/o"):
+            os.makedirs(os.path.dirname(f"{d}/source/{p}"), exist_ok=True)
+            with open(f"{d}/source/{p}", "w+") as fd: fd.write(p)
+        with open(f"{d}/source/src/app/cache/CACHEDIR.TAG", "w+") as fd: fd.write("Signature: 8a477f597d28d172789f06886806bc55\n")
+        with open(f"{d}/source/src/app/secret/.nobackup", "w+") as fd: fd.write("")
+        with open(f"{d}/exclude", "w+") as fd: fd.write("tmp\n")
+
+        b = check_output([uback, "backup", source, dest]).strip().decode()
+        s = b.split("-")[0]
+        os.mkdir(f"{d}/restore")
+        check_call([uback, "restore", "-d", f"{d}/restore", dest])
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}")), {"src"})
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}/src")), {"app"})
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}/src/app")), {"cache", "main.c"})
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}/src/app/cache")), {"CACHEDIR.TAG"})
+
+    def test_native_tar_source_filters(self):
+        with tempfile.TemporaryDirectory() as d:
+            source = f"type=tar,native=true,path={d}/source,key-file={d}/backup.pub,@include=src/app,@exclude=node_modules,exclude-file={d}/exclude,exclude-caches,exclude-if-present=.nobackup,one-file-system"
+            self._test_filters(d, source)
+
+    def test_gnu_tar_source_filters(self):
+        with tempfile.TemporaryDirectory() as d:
+            source = f"type=tar,native=false,path={d}/source,key-file={d}/backup.pub,@include=src/app,@exclude=node_modules,exclude-file={d}/exclude,exclude-caches,exclude-if-present=.nobackup,one-file-system"
+            self._test_filters(d, source)
+
+    def test_tar_source_exclude_patterns(self):
+        # Both modes must exclude the same files ; expected results are those of GNU tar --exclude
+        files = ("src/app/tmp/f", "src/tmp/g", "tmp/h", "var/cache/c", "x/var/cache/d", "src/app/main.c", "a.b/k")
+        dirs = {"src", "src/app", "src/app/tmp", "src/tmp", "tmp", "var", "var/cache", "x", "x/var", "x/var/cache", "a.b"}
+        everything = dirs | set(files)
+        cases = [
+            ("tmp", {"src/app/tmp", "src/app/tmp/f", "src/tmp", "src/tmp/g", "tmp", "tmp/h"}),
+            ("tmp/", set()),
+            ("./tmp", {"tmp", "tmp/h"}),
+            ("*/tmp", {"src/app/tmp", "src/app/tmp/f", "src/tmp", "src/tmp/g", "tmp", "tmp/h"}),
+            ("src/*", {"src/app", "src/app/tmp", "src/app/tmp/f", "src/app/main.c", "src/tmp", "src/tmp/g"}),
+            ("var/cache", {"var/cache", "var/cache/c", "x/var/cache", "x/var/cache/d"}),
+            ("./var/cache", {"var/cache", "var/cache/c"}),
+            ("app/tmp/f", {"src/app/tmp/f"}),
+            ("src/app/t*", {"src/app/tmp", "src/app/tmp/f"}),
+            ("*.c", {"src/app/main.c"}),
+            ("\\*.c", set()),
+            ("a?b", {"a.b", "a.b/k"}),
+            ("[ab].b", {"a.b", "a.b/k"}),
+            ("[!ab].b", set()),
+        ]
+
+        with tempfile.TemporaryDirectory() as d:
+            for p in files:
+                os.makedirs(os.path.dirname(f"{d}/source/{p}"), exist_ok=True)
+                with open(f"{d}/source/{p}", "w+") as fd: fd.write(p)
+
+            for i, (pattern, excluded) in enumerate(cases):
+                with open(f"{d}/exclude", "w+") as fd: fd.write(f"{pattern}\n")
+                for native in ("true", "false"):
+                    with self.subTest(pattern=pattern, native=native):
+                        source = f"type=tar,native={native},path={d}/source,no-encryption=1,exclude-file={d}/exclude"
+                        dest = f"id=test,type=fs,path={d}/backups-{i}-{native},no-encryption=1"
+                        s = check_output([uback, "backup", source, dest]).strip().decode().split("-")[0]
+                        listing = check_output([uback, "ls", dest, s]).decode().splitlines()
+                        self.assertEqual({l.split()[-1] for l in listing}, everything - excluded)
+
+    def test_tar_source_exclude_file_whitespace(self):
+        # Like with GNU tar, leading whitespace is part of the patterns of ExcludeFile, trailing
+        # whitespace is not
+        with tempfile.TemporaryDirectory() as d:
+            ensure_dir(f"{d}/source")
+            for p in ("a", " a", "b", "b ", "c", "c "):
+                with open(f"{d}/source/{p}", "w+") as fd: fd.write(p)
+            with open(f"{d}/exclude", "w+") as fd: fd.write(" a\nb \r\n\n")
+
+            for native in ("true", "false"):
+                with self.subTest(native=native):
+                    source = f"type=tar,native={native},path={d}/source,no-encryption=1,exclude-file={d}/exclude"
+                    dest = f"id=test,type=fs,path={d}/backups-{native},no-encryption=1"
+                    s = check_output([uback, "backup", source, dest]).strip().decode().split("-")[0]
+                    check_call([uback, "restore", "-d", f"{d}/restore-{native}", dest])
+                    self.assertEqual(set(os.listdir(f"{d}/restore-{native}/{s}")), {"a", "b ", "c", "c "})
+
+    def _test_paths(self, d, native):
+        check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+        source = f"type=tar,native={native},@paths={d}/etc,@paths={d}/var/lib/app,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly"
+        dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+        os.makedirs(f"{d}/etc")
+        os.makedirs(f"{d}/var/lib/app")
+        os.makedirs(f"{d}/var/lib/other")
+        with open(f"{d}/etc/a", "w+") as fd: fd.write("a")
+        with open(f"{d}/var/lib/app/b", "w+") as fd: fd.write("b")
+        with open(f"{d}/var/lib/other/c", "w+") as fd: fd.write("c")
+        check_output([uback, "backup", source, dest])
+        time.sleep(0.01)
+
+        with open(f"{d}/var/lib/app/b", "w+") as fd: fd.write("b2")
+        b = check_output([uback, "backup", source, dest]).strip().decode()
+        s = b.split("-")[0]
+        self.assertFalse(b.endswith("-full"))
+
+        os.mkdir(f"{d}/restore")
+        check_call([uback, "restore", "-d", f"{d}/restore", dest])
+        self.assertEqual(b"a", read_file(f"{d}/restore/{s}{d}/etc/a"))
+        self.assertEqual(b"b2", read_file(f"{d}/restore/{s}{d}/var/lib/app/b"))
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}{d}")), {"etc", "var"})
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}{d}/var/lib")), {"app"})
+
+    def test_native_tar_source_paths(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._test_paths(d, "true")
+
+    def test_gnu_tar_source_paths(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._test_paths(d, "false")
+
+    def _test_partial_restore(self, d, native):
+        source = f"type=tar,path={d}/source,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,native={native}"
+        dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key"
+        check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+        for p in ("a", "sub/c", "sub/d", "sub/deep/e", "other/f"):
+            os.makedirs(os.path.dirname(f"{d}/source/{p}"), exist_ok=True)
+            with open(f"{d}/source/{p}", "w+") as fd: fd.write(p)
+        check_call([uback, "backup", source, dest])
+        time.sleep(0.01)
+
+        with open(f"{d}/source/sub/c", "w+") as fd: fd.write("c2")
+        os.unlink(f"{d}/source/sub/d")
+        b = check_output([uback, "backup", source, dest]).strip().decode()
+        s = b.split("-")[0]
+        self.assertFalse(b.endswith("-full"))
+
+        os.mkdir(f"{d}/restore")
+        check_call([uback, "restore", "-d", f"{d}/restore", "--path", "sub/c", "--path", "sub/de*", dest])
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}")), {"sub"})
+        self.assertEqual(set(os.listdir(f"{d}/restore/{s}/sub")), {"c", "deep"})
+        self.assertEqual(b"c2", read_file(f"{d}/restore/{s}/sub/c"))
+        self.assertEqual(b"sub/deep/e", read_file(f"{d}/restore/{s}/sub/deep/e"))
+
+        listing = check_output([uback, "ls", dest, s]).decode().splitlines()
+        self.assertEqual([l.split()[-1] for l in listing], ["a", "other", "other/f", "sub", "sub/c", "sub/deep", "sub/deep/e"])
+        self.assertTrue(listing[4].split()[1] == "2")
+        listing = check_output([uback, "ls", dest, s, "sub/deep"]).decode().splitlines()
+        self.assertEqual([l.split()[-1] for l in listing], ["sub/deep", "sub/deep/e"])
+
+    def test_native_tar_source_partial_restore(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._test_partial_restore(d, "true")
+
+    def test_gnu_tar_source_partial_restore(self):
+        with tempfile.TemporaryDirectory() as d:
+            self._test_partial_restore(d, "false")
+
+    def test_tar_source_catalog(self):
+        with tempfile.TemporaryDirectory() as d:
+            source = f"type=tar,path={d}/source,key-file={d}/backup.pub,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly"
+            dest = f"id=test,type=fs,path={d}/backups,key-file={d}/backup.key,@retention-policy=daily=1"
+            check_call([uback, "key", "gen", f"{d}/backup.key", f"{d}/backup.pub"])
+            os.makedirs(f"{d}/source/etc")
+            with open(f"{d}/source/etc/app.conf", "w+") as fd: fd.write("v1")
+            with open(f"{d}/source/other", "w+") as fd: fd.write("o")
+            b1 = check_output([uback, "backup", "-n", source, dest]).strip().decode()
+            time.sleep(0.01)
+            with open(f"{d}/source/etc/app.conf", "w+") as fd: fd.write("v2")
+            b2 = check_output([uback, "backup", "-n", source, dest]).strip().decode()
+            self.assertEqual(set(os.listdir(f"{d}/backups")), {f"{b1}.ubkp", f"{b1}.ubkc", f"{b2}.ubkp", f"{b2}.ubkc"})
+            self.assertEqual(set(check_output([uback, "list", "backups", dest]).decode().splitlines()), {f"{b1.split('-')[0]} (full)", f"{b2.split('-')[0]} (base: {b1.split('-')[0]})"})
+
+            found = [l.split() for l in check_output([uback, "find", dest, "*.conf"]).decode().splitlines()]
+            self.assertEqual([(f[0], f[2], f[-1]) for f in found], [(b1, "2", "etc/app.conf"), (b2, "2", "etc/app.conf")])
+            self.assertEqual(found[0][-2], hashlib.sha256(b"v1").hexdigest())
+            self.assertEqual(found[1][-2], hashlib.sha256(b"v2").hexdigest())
+            self.assertEqual(check_output([uback, "find", dest, "missing"]), b"")
+
+            # Pruning a backup removes its catalog
+            time.sleep(0.01)
+            b3 = check_output([uback, "backup", "-n", "-f", source, dest]).strip().decode()
+            check_call([uback, "prune", "backups", dest])
+            self.assertEqual(set(os.listdir(f"{d}/backups")), {f"{b3}.ubkp", f"{b3}.ubkc"})
+
+    def _test_base_selection(self, d, options):
+        ensure_dir(f"{d}/source")
+        source = f"type=tar,path={d}/source,no-encryption=1,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,@retention-policy=last=10,{options}"
+        dest = f"id=test,type=fs,path={d}/backups,no-encryption=1"
+        backups = []
+        for i in range(4):
+            with open(f"{d}/source/a", "w+") as fd: fd.write(f"v{i}")
+            backups.append(check_output([uback, "backup", source, dest]).strip().decode())
+            time.sleep(0.01)
+        return [b.split("-", 1)[1] for b in backups], [b.split("-")[0] for b in backups]
+
+    def test_tar_source_max_chain_length(self):
+        with tempfile.TemporaryDirectory() as d:
+            bases, snapshots = self._test_base_selection(d, "max-chain-length=2")
+            self.assertEqual(bases, ["full", f"from-{snapshots[0]}", f"from-{snapshots[1]}", "full"])
+
+    def test_tar_source_incomplete_chain(self):
+        with tempfile.TemporaryDirectory() as d:
+            bases, snapshots = self._test_base_selection(d, "")
+            self.assertEqual(bases, ["full", f"from-{snapshots[0]}", f"from-{snapshots[1]}", f"from-{snapshots[2]}"])
+
+            # Incremental backups from a chain with a lost backup could not be restored
+            os.unlink(f"{d}/backups/{snapshots[1]}-from-{snapshots[0]}.ubkp")
+            source = f"type=tar,path={d}/source,no-encryption=1,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,@retention-policy=last=10"
+            dest = f"id=test,type=fs,path={d}/backups,no-encryption=1"
+            out = check_output([uback, "backup", "--dry-run", source, dest]).decode().splitlines()
+            self.assertIn(f"reason: base of {snapshots[2]} missing from the destination, full backup forced", out)
+            self.assertTrue(check_output([uback, "backup", source, dest]).strip().decode().endswith("-full"))
+
+    def test_tar_source_differential(self):
+        with tempfile.TemporaryDirectory() as d:
+            bases, snapshots = self._test_base_selection(d, "differential=true")
+            self.assertEqual(bases, ["full", f"from-{snapshots[0]}", f"from-{snapshots[0]}", f"from-{snapshots[0]}"])
+
+    def test_tar_source_backup_dry_run(self):
+        with tempfile.TemporaryDirectory() as d:
+            ensure_dir(f"{d}/source")
+            source = f"type=tar,path={d}/source,no-encryption=1,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly"
+            dest = f"id=test,type=fs,path={d}/backups,no-encryption=1,@retention-policy=last=1"
+
+            out = check_output([uback, "backup", "--dry-run", source, dest]).decode().splitlines()
+            self.assertIn("reason: no full backup found, full backup forced", out)
+            self.assertTrue(any(l.startswith("backup: ") and l.endswith("-full") for l in out))
+            self.assertFalse(os.path.exists(f"{d}/state.json"))
+            self.assertEqual(os.listdir(f"{d}/backups"), [])
+
+            b1 = check_output([uback, "backup", source, dest]).strip().decode()
+            s1 = b1.split("-")[0]
+            time.sleep(0.01)
+            b2 = check_output([uback, "backup", "-f", source, dest]).strip().decode()
+            s2 = b2.split("-")[0]
+
+            out = check_output([uback, "backup", "--dry-run", source, dest]).decode().splitlines()
+            self.assertTrue(any(l.startswith("backup: ") and l.endswith(f"-from-{s2}") for l in out))
+            self.assertIn(f"prune snapshot: {s2} (not retained by any retention policy)", out)
+            self.assertFalse(any(l.startswith(f"prune backup: {b2}") for l in out))
+
+            out = check_output([uback, "backup", "--dry-run", "-f", source, dest]).decode().splitlines()
+            self.assertIn(f"prune backup: {b2} (not retained by any retention policy)", out)
+            self.assertEqual(set(os.listdir(f"{d}/snapshots")), {s2})
+            self.assertEqual(set(os.listdir(f"{d}/backups")), {f"{b2}.ubkp", f"{b2}.ubkc"})
+
+    def test_tar_source_size_estimation(self):
+        with tempfile.TemporaryDirectory() as d:
+            os.makedirs(f"{d}/source/sub")
+            os.makedirs(f"{d}/source/excluded")
+            with open(f"{d}/source/a", "wb") as fd: fd.write(b"a" * 1000)
+            with open(f"{d}/source/sub/b", "wb") as fd: fd.write(b"b" * 2000)
+            with open(f"{d}/source/excluded/c", "wb") as fd: fd.write(b"c" * 4000)
+            source = f"type=tar,path={d}/source,no-encryption=1,state-file={d}/state.json,snapshots-path={d}/snapshots,full-interval=weekly,@exclude=excluded"
+            dest = f"id=test,type=fs,path={d}/backups,no-encryption=1"
+
+            out = check_output([uback, "backup", "--dry-run", source, dest]).decode().splitlines()
+            self.assertIn("estimated size: 2.9K (before compression)", out)
+
+            check_call([uback, "backup", source, dest])
+            time.sleep(0.01)
+            with open(f"{d}/source/a", "wb") as fd: fd.write(b"a" * 1024)
+            out = check_output([uback, "backup", "--dry-run", source, dest]).decode().splitlines()
+            self.assertIn("estimated size: 1.0K (before compression)", out)